@@ -1,45 +1,40 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/config"
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/web"
 	"github.com/sirupsen/logrus"
 )
 
-const readinessTime = 360
-
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Do stuff here
-		logrus.Info("%v ")
+		logrus.Infof("%v %v", r.Method, r.URL.Path)
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
 		next.ServeHTTP(w, r)
 	})
 }
 
-func initCollector(u *url.URL, project, email, password string, keepUntil int64) *cypresscollector.CypressDashboardCollector {
-	cypressCollector, err := cypresscollector.NewCypressDashboardCollector(*u, project, email, password, keepUntil)
-	if err != nil {
-		logrus.Panicln(err)
-	}
-	return cypressCollector
-}
-
-// Convert the number of days into seconds
-func toSeconds(days int64) int64 {
-	return days * int64(time.Hour) * 24
-}
-
 func main() {
+	configFile := flag.String("config", "",
+		"YAML configuration file. If set, the flags configuring the projects, polling, labels, retention and outputs are ignored.")
 	listen := flag.String("listen", "0.0.0.0:8081", "host:port to listen")
-	project := flag.String("project", "7s5okt", "host:port to listen")
+	project := flag.String("project", "7s5okt", "ID of the dashboard project to monitor")
 	keepUntil := flag.Int64("keepUntil", 14,
 		"Time ( in days ) to keep in memory the results of a test/run before removing it.")
 
@@ -47,6 +42,37 @@ func main() {
 	password := flag.String("password", "", "password to connect to the dashboard")
 	debug := flag.Bool("debug", false, "activate debug logging")
 
+	authorLabel := flag.String("authorLabel", "",
+		"Add the commit author as an author label on run metrics. One of raw, hash, domain or mapping. Disabled if empty.")
+	authorSalt := flag.String("authorSalt", "", "salt used to hash the author email when authorLabel is hash")
+	authorMapping := flag.String("authorMapping", "",
+		"JSON file mapping author emails to team names, used when authorLabel is mapping")
+
+	metricNames := flag.String("metricNames", "current",
+		"Naming schema of the metrics : current ( base units, Prometheus conventions ), legacy ( names before the migration ) or both")
+	runTimestamps := flag.Bool("runTimestamps", false,
+		"Send the _last gauges with the completion time of their run, instead of the scrape time")
+	runTimestampsMaxAge := flag.Duration("runTimestampsMaxAge", cypresscollector.DefaultTimestampMaxAge,
+		"Oldest timestamp sent with runTimestamps. Older runs are sent with this age, so that prometheus doesn't reject them. Above the lookback delta of prometheus ( 5m ), they're only visible to range queries.")
+	openMetrics := flag.Bool("openMetrics", true,
+		"Serve the OpenMetrics format when requested by prometheus, needed for the exemplars linking metrics to the runs")
+	dashboardURL := flag.String("dashboardURL", cypresscollector.DefaultDashboardURL, "URL of the dashboard, used to link exemplars to the runs")
+	testDurationBuckets := flag.String("testDurationBuckets", "",
+		"Comma separated buckets ( in seconds ) of the cypress_test_duration_seconds histogram. Uses the default buckets if empty.")
+	runDurationBuckets := flag.String("runDurationBuckets", "",
+		"Comma separated buckets ( in seconds ) of the cypress_run_duration_seconds histogram. Uses the default buckets if empty.")
+	nativeHistogramBucketFactor := flag.Float64("nativeHistogramBucketFactor", 0,
+		"Also expose duration histograms as native histograms, with this growth factor between buckets ( eg 1.1 ). Disabled if 0.")
+
+	pollInterval := flag.Duration("pollInterval", time.Minute, "Interval between two syncs with the dashboard")
+	readinessThreshold := flag.Duration("readinessThreshold", config.DefaultReadinessThreshold,
+		"/ready fails when the last successful sync with the dashboard is older than this")
+
+	webConfigFile := flag.String("webConfigFile", "",
+		"Web configuration file enabling TLS and authentication, in the format of the Prometheus exporter-toolkit")
+	shutdownGracePeriod := flag.Duration("shutdownGracePeriod", 30*time.Second,
+		"Time given on SIGTERM or SIGINT to stop syncing with the dashboard and to answer the pending requests")
+
 	flag.Parse()
 	if *debug {
 		logrus.SetLevel(logrus.DebugLevel)
@@ -59,12 +85,136 @@ func main() {
 		logrus.Panicln("Impossible to parse URL ", err)
 	}
 
-	ddCollector := initCollector(parsedURL, *project, *email, *password, toSeconds(*keepUntil))
-	logrus.Infoln("Monitoring Cypress dashboard at ", parsedURL, "for project ID ", *project)
-	logrus.Infoln("Keeping old timeseries for %v days ", *keepUntil)
-	prometheus.MustRegister(ddCollector)
-	http.Handle("/metrics", promhttp.Handler())
+	var cfg *config.Config
+	if *configFile != "" {
+		logrus.Infoln("Loading configuration file", *configFile)
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			logrus.Panicln(err)
+		}
+	} else {
+		flagsConfig := config.Default()
+		cfg = &flagsConfig
+		cfg.Projects = []config.Project{{ID: *project}}
+		if *email != "" || *password != "" {
+			cfg.Credentials["flags"] = config.Credentials{Email: *email, Password: *password}
+			cfg.Projects[0].Credentials = "flags"
+			cfg.Modules[config.DefaultModule] = config.Module{Credentials: "flags"}
+		}
+		cfg.Polling.Interval = *pollInterval
+		cfg.Polling.ReadinessThreshold = *readinessThreshold
+		cfg.Retention.KeepUntil = time.Duration(*keepUntil) * 24 * time.Hour
+		cfg.Labels.Author = config.AuthorLabel{Mode: *authorLabel, Salt: *authorSalt, MappingFile: *authorMapping}
+		cfg.Outputs.MetricNames = *metricNames
+		cfg.Outputs.OpenMetrics = *openMetrics
+		cfg.Outputs.DashboardURL = *dashboardURL
+		cfg.Outputs.RunTimestamps = config.RunTimestamps{Enabled: *runTimestamps, MaxAge: *runTimestampsMaxAge}
+		cfg.Outputs.Histograms.NativeBucketFactor = *nativeHistogramBucketFactor
+		cfg.Outputs.Histograms.TestDurationBuckets, err = cypresscollector.ParseBuckets(*testDurationBuckets)
+		if err != nil {
+			logrus.Panicln(err)
+		}
+		cfg.Outputs.Histograms.RunDurationBuckets, err = cypresscollector.ParseBuckets(*runDurationBuckets)
+		if err != nil {
+			logrus.Panicln(err)
+		}
+		if err := cfg.Validate(); err != nil {
+			logrus.Panicln(err)
+		}
+	}
 
-	logrus.Info("Listening ", *listen)
-	logrus.Fatal(http.ListenAndServe(*listen, handlers.LoggingHandler(logrus.New().Out, http.DefaultServeMux)))
+	monitored := newProjects(*parsedURL)
+	if err := monitored.apply(cfg); err != nil {
+		logrus.Panicln(err)
+	}
+	probed := newProbes(*parsedURL)
+	probed.apply(cfg)
+	logrus.Infof("Keeping old timeseries for %v", cfg.Retention.KeepUntil)
+
+	reload := func() error {
+		if *configFile == "" {
+			return errors.New("no configuration file to reload, the exporter is configured with flags")
+		}
+		cfg, err := config.Load(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := monitored.apply(cfg); err != nil {
+			return err
+		}
+		probed.apply(cfg)
+		logrus.Infoln("Reloaded configuration file", *configFile)
+		return nil
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload(); err != nil {
+				logrus.Errorln("Error while reloading the configuration, keeping the previous one:", err)
+			}
+		}
+	}()
+
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			opts := promhttp.HandlerOpts{EnableOpenMetrics: monitored.current().Outputs.OpenMetrics}
+			promhttp.HandlerFor(monitored, opts).ServeHTTP(w, r)
+		}),
+	))
+	http.Handle("/probe", probed)
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "reload requires a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := reload(); err != nil {
+			logrus.Errorln("Error while reloading the configuration, keeping the previous one:", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		if err := monitored.ready(); err != nil {
+			// The endpoint doesn't require authentication, the projects and their errors are only logged
+			logrus.Warnln("Not ready:", err)
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	server := &http.Server{
+		Addr:    *listen,
+		Handler: handlers.LoggingHandler(logrus.New().Out, http.DefaultServeMux),
+	}
+	go func() {
+		logrus.Info("Listening ", *listen)
+		if err := web.ListenAndServe(server, *webConfigFile, "/healthz", "/ready"); err != nil && err != http.ErrServerClosed {
+			logrus.Fatal(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	sig := <-stop
+	logrus.Infof("Received %v, shutting down within %v", sig, *shutdownGracePeriod)
+	signal.Stop(hup)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownGracePeriod)
+	defer cancel()
+	// Stop syncing first, so that the last scrapes get the final state
+	if err := monitored.shutdown(ctx); err != nil {
+		logrus.Errorln("Error while stopping the syncs with the dashboard:", err)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.Errorln("Error while stopping the HTTP server:", err)
+	}
+	// Runs are only kept in memory, there's no state to flush
+	logrus.Info("Cypress dashboard exporter stopped")
 }
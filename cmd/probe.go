@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/config"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector"
+	"github.com/sirupsen/logrus"
+)
+
+// Used when prometheus doesn't send its scrape timeout
+const defaultProbeTimeout = 10 * time.Second
+
+var errTooManyTargets = errors.New("too many probed projects, see probe.maxTargets")
+
+type probeKey struct {
+	project string
+	module  string
+}
+
+// probeCollector is the collector of a project scraped through /probe. It's synced by the probes, instead of a poller.
+type probeCollector struct {
+	collector *cypresscollector.CypressDashboardCollector
+	registry  *prometheus.Registry
+	lastProbe time.Time
+}
+
+// probes manages the collectors of the projects scraped through /probe. They're created on the first probe, and
+// forgotten once they haven't been probed for a while.
+type probes struct {
+	mu         sync.Mutex
+	endpoint   url.URL
+	config     *config.Config
+	collectors map[probeKey]*probeCollector
+}
+
+func newProbes(endpoint url.URL) *probes {
+	return &probes{
+		endpoint:   endpoint,
+		collectors: map[probeKey]*probeCollector{},
+	}
+}
+
+// apply reloads the collectors with the new settings of their module, and forgets the ones of removed modules.
+func (p *probes) apply(cfg *config.Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, pc := range p.collectors {
+		email, password, opts, err := cfg.ModuleSettings(key.module)
+		if err != nil {
+			logrus.Infof("Forgetting probed project %v : %v", key.project, err)
+			delete(p.collectors, key)
+			continue
+		}
+		pc.collector.Reload(email, password, int64(cfg.Retention.KeepUntil), opts)
+	}
+	p.config = cfg
+}
+
+// get returns the collector of a project and a module, creating it on the first probe.
+func (p *probes) get(key probeKey, now time.Time) (*probeCollector, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for k, pc := range p.collectors {
+		if now.Sub(pc.lastProbe) > p.config.Probe.IdleTimeout {
+			logrus.Infof("Forgetting project %v of module %v, it hasn't been probed since %v", k.project, k.module, pc.lastProbe)
+			delete(p.collectors, k)
+		}
+	}
+
+	if pc, ok := p.collectors[key]; ok {
+		pc.lastProbe = now
+		return pc, nil
+	}
+	if len(p.collectors) >= p.config.Probe.MaxTargets {
+		return nil, errTooManyTargets
+	}
+	email, password, opts, err := p.config.ModuleSettings(key.module)
+	if err != nil {
+		return nil, err
+	}
+	collector, err := cypresscollector.NewCypressDashboardCollector(p.endpoint, key.project, email, password, int64(p.config.Retention.KeepUntil), opts)
+	if err != nil {
+		return nil, err
+	}
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(collector); err != nil {
+		return nil, err
+	}
+	logrus.Infof("Probing project %v with module %v", key.project, key.module)
+	pc := &probeCollector{collector: collector, registry: registry, lastProbe: now}
+	p.collectors[key] = pc
+	return pc, nil
+}
+
+func (p *probes) current() *config.Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+// probeTimeout returns the time left to answer prometheus, from its scrape timeout.
+func probeTimeout(r *http.Request) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return defaultProbeTimeout
+	}
+	// Keep some time to send the metrics
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > time.Second {
+		timeout -= 500 * time.Millisecond
+	}
+	return timeout
+}
+
+// ServeHTTP syncs the project given with the `project` parameter, with the settings of the `module` parameter, and
+// returns its metrics. If the sync fails, the metrics of the previous probes are returned.
+func (p *probes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := probeKey{
+		project: r.URL.Query().Get("project"),
+		module:  r.URL.Query().Get("module"),
+	}
+	if key.project == "" {
+		http.Error(w, "project parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if key.module == "" {
+		key.module = config.DefaultModule
+	}
+	pc, err := p.get(key, time.Now())
+	if errors.Is(err, errTooManyTargets) {
+		logrus.Warnf("Refusing to probe project %v : %v", key.project, err)
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't probe project %v : %v", key.project, err), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout(r))
+	defer cancel()
+	// Probed collectors have no poller, the expired entries are removed at each probe instead
+	pc.collector.Sweep()
+	if err := pc.collector.Sync(ctx); err != nil {
+		logrus.Errorf("Error while probing project %v : %v", key.project, err)
+	}
+
+	opts := promhttp.HandlerOpts{EnableOpenMetrics: p.current().Outputs.OpenMetrics}
+	promhttp.HandlerFor(pc.registry, opts).ServeHTTP(w, r)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/config"
+)
+
+func TestProbes_get_maxTargets(t *testing.T) {
+	cfg := config.Default()
+	cfg.Probe.MaxTargets = 2
+	p := newProbes(url.URL{})
+	p.apply(&cfg)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, project := range []string{"a", "b"} {
+		if _, err := p.get(probeKey{project, config.DefaultModule}, now); err != nil {
+			t.Fatalf("probes.get(%v) error = %v", project, err)
+		}
+	}
+	if _, err := p.get(probeKey{"c", config.DefaultModule}, now); !errors.Is(err, errTooManyTargets) {
+		t.Errorf("probes.get(c) error = %v, want %v", err, errTooManyTargets)
+	}
+	if _, err := p.get(probeKey{"a", config.DefaultModule}, now); err != nil {
+		t.Errorf("probes.get(a) error = %v, the probed projects should still be accepted", err)
+	}
+
+	// Once forgotten, the targets make room for new projects
+	if _, err := p.get(probeKey{"c", config.DefaultModule}, now.Add(2*cfg.Probe.IdleTimeout)); err != nil {
+		t.Errorf("probes.get(c) error = %v, want a new target once the idle ones are forgotten", err)
+	}
+}
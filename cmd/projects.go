@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/config"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector"
+	"github.com/sirupsen/logrus"
+)
+
+// projectCollector is the collector of a project, with its own registry and poller.
+type projectCollector struct {
+	collector *cypresscollector.CypressDashboardCollector
+	registry  *prometheus.Registry
+	interval  time.Duration
+	cancel    context.CancelFunc
+	// Closed when the poller returned
+	done chan struct{}
+}
+
+func (p *projectCollector) start(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	p.interval = interval
+	p.cancel = cancel
+	p.done = done
+	go func() {
+		defer close(done)
+		p.collector.Run(ctx, interval)
+	}()
+}
+
+// stop cancels the poller, and waits for it to return or for the context to be done.
+func (p *projectCollector) stop(ctx context.Context) error {
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// projects manages the collectors of the configured projects, so that the configuration can be reloaded without
+// losing the runs they already processed.
+type projects struct {
+	mu         sync.Mutex
+	endpoint   url.URL
+	config     *config.Config
+	collectors map[string]*projectCollector
+	// Set on shutdown, so that a reload can't start pollers again
+	stopped bool
+}
+
+func newProjects(endpoint url.URL) *projects {
+	return &projects{
+		endpoint:   endpoint,
+		collectors: map[string]*projectCollector{},
+	}
+}
+
+// apply starts, reloads or stops the collectors to match the configuration.
+// Nothing is changed if a collector can't be created.
+func (p *projects) apply(cfg *config.Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return errors.New("the exporter is shutting down")
+	}
+
+	opts, err := cfg.CollectorOptions()
+	if err != nil {
+		return err
+	}
+	keepUntil := int64(cfg.Retention.KeepUntil)
+
+	next := map[string]*projectCollector{}
+	created := map[string]bool{}
+	// Existing collectors are only reloaded once all the new ones could be created
+	reloads := []func(){}
+	for _, project := range cfg.Projects {
+		email, password, err := cfg.ProjectCredentials(project)
+		if err != nil {
+			return err
+		}
+		if existing, ok := p.collectors[project.ID]; ok {
+			next[project.ID] = existing
+			reloads = append(reloads, func() { existing.collector.Reload(email, password, keepUntil, opts) })
+			continue
+		}
+		collector, err := cypresscollector.NewCypressDashboardCollector(p.endpoint, project.ID, email, password, keepUntil, opts)
+		if err != nil {
+			return fmt.Errorf("project %v : %v", project.ID, err)
+		}
+		registry := prometheus.NewRegistry()
+		if err := registry.Register(collector); err != nil {
+			return fmt.Errorf("project %v : %v", project.ID, err)
+		}
+		next[project.ID] = &projectCollector{collector: collector, registry: registry}
+		created[project.ID] = true
+	}
+
+	for _, reload := range reloads {
+		reload()
+	}
+	for id, pc := range p.collectors {
+		if _, ok := next[id]; !ok {
+			logrus.Infoln("Stop monitoring project ID", id)
+			pc.cancel()
+		}
+	}
+	for id, pc := range next {
+		if created[id] {
+			logrus.Infoln("Monitoring Cypress dashboard at", p.endpoint.String(), "for project ID", id)
+			pc.start(cfg.Polling.Interval)
+		} else if pc.interval != cfg.Polling.Interval {
+			// Wait for the previous poller, which cancels its sync in progress, so that two pollers never run
+			// against the collector
+			pc.cancel()
+			<-pc.done
+			pc.start(cfg.Polling.Interval)
+		}
+	}
+	p.collectors = next
+	p.config = cfg
+	return nil
+}
+
+// shutdown stops the pollers of all the projects, canceling the syncs in progress.
+// It returns once they're all stopped, or when the context is done.
+func (p *projects) shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for _, pc := range p.collectors {
+		pc.cancel()
+	}
+	for id, pc := range p.collectors {
+		if err := pc.stop(ctx); err != nil {
+			return fmt.Errorf("project %v : %v", id, err)
+		}
+	}
+	return nil
+}
+
+func (p *projects) current() *config.Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+// Gather collects the metrics of the exporter and of all the projects.
+func (p *projects) Gather() ([]*dto.MetricFamily, error) {
+	p.mu.Lock()
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+	for _, pc := range p.collectors {
+		gatherers = append(gatherers, pc.registry)
+	}
+	p.mu.Unlock()
+	return gatherers.Gather()
+}
+
+// ready returns an error if one of the projects wasn't synced recently.
+func (p *projects) ready() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.collectors))
+	for id := range p.collectors {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := p.collectors[id].collector.Ready(p.config.Polling.ReadinessThreshold); err != nil {
+			return fmt.Errorf("project %v : %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFake_Advance(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewFake(start)
+	ticker := c.NewTicker(time.Minute)
+
+	c.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired before its interval")
+	default:
+	}
+
+	// Like a time.Ticker, the ticks that aren't received are dropped
+	c.Advance(3 * time.Minute)
+	if tick := <-ticker.C(); !tick.Equal(start.Add(time.Minute)) {
+		t.Errorf("tick = %v, want %v", tick, start.Add(time.Minute))
+	}
+	select {
+	case tick := <-ticker.C():
+		t.Errorf("unexpected tick %v", tick)
+	default:
+	}
+	if now := c.Now(); !now.Equal(start.Add(210 * time.Second)) {
+		t.Errorf("Now() = %v, want %v", now, start.Add(210*time.Second))
+	}
+
+	ticker.Stop()
+	c.Advance(time.Hour)
+	select {
+	case <-ticker.C():
+		t.Error("stopped ticker fired")
+	default:
+	}
+	if c.Tickers() != 0 {
+		t.Errorf("Tickers() = %v, want 0", c.Tickers())
+	}
+}
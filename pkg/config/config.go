@@ -0,0 +1,529 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector"
+	"gopkg.in/yaml.v3"
+)
+
+// Default time after the last successful sync during which the exporter stays ready
+const DefaultReadinessThreshold = 6 * time.Minute
+
+// Config is the content of the configuration file, eg :
+//
+//	credentials:
+//	  ci:
+//	    email: ci@example.com
+//	    passwordEnv: CYPRESS_PASSWORD
+//	projects:
+//	  - id: 7s5okt
+//	    credentials: ci
+//	polling:
+//	  interval: 1m
+//	retention:
+//	  keepUntil: 336h
+type Config struct {
+	// Credentials to log in to the dashboard, referenced by name from the projects
+	Credentials map[string]Credentials `yaml:"credentials"`
+	Projects    []Project              `yaml:"projects"`
+	Polling     Polling                `yaml:"polling"`
+	Labels      Labels                 `yaml:"labels"`
+	Retention   Retention              `yaml:"retention"`
+	Outputs     Outputs                `yaml:"outputs"`
+	// Settings of the projects scraped through /probe, selected with the module parameter
+	Modules map[string]Module `yaml:"modules"`
+	Probe   Probe             `yaml:"probe"`
+}
+
+// Credentials of a dashboard user. The password is given by at most one of Password, PasswordFile or PasswordEnv.
+type Credentials struct {
+	Email        string `yaml:"email"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"passwordFile"`
+	PasswordEnv  string `yaml:"passwordEnv"`
+}
+
+type Project struct {
+	ID string `yaml:"id"`
+	// Name of the credentials used for this project. Public projects don't need any.
+	Credentials string `yaml:"credentials"`
+}
+
+// Module bundles the settings of the projects scraped through /probe.
+type Module struct {
+	// Name of the credentials used for the projects. Public projects don't need any.
+	Credentials string `yaml:"credentials"`
+	// Labels of the projects, the global ones if not set
+	Labels *Labels `yaml:"labels"`
+}
+
+// Module used when /probe has no module parameter. If it's not configured, it uses the global labels without credentials.
+const DefaultModule = "default"
+
+type Probe struct {
+	// Projects that haven't been probed for this time are forgotten
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// Maximum number of probed projects, the probes of new projects are refused beyond it
+	MaxTargets int `yaml:"maxTargets"`
+}
+
+// Each probed project has its own collector and logs in to the dashboard, the targets are limited so that a scan of
+// /probe can't exhaust the memory or get the credentials locked out.
+const DefaultProbeMaxTargets = 50
+
+type Polling struct {
+	Interval time.Duration `yaml:"interval"`
+	// Number of runs fetched by the first sync
+	Backlog int `yaml:"backlog"`
+	// /ready fails when the last successful sync is older than this
+	ReadinessThreshold time.Duration `yaml:"readinessThreshold"`
+}
+
+type Labels struct {
+	Author AuthorLabel `yaml:"author"`
+	// Labels of each family of metrics, the default ones for the missing families
+	Families map[string][]string `yaml:"families"`
+	Custom   []CustomLabel       `yaml:"custom"`
+	Branches Branches            `yaml:"branches"`
+	// Maximum number of label combinations of the run, test and spec families
+	Limits map[string]int `yaml:"limits"`
+}
+
+// Branches normalizes the git_branch label. Regexes are anchored.
+type Branches struct {
+	// Branches exported as-is
+	Keep []string `yaml:"keep"`
+	// The first matching rule gives the label value of the other branches
+	Rules []BranchRule `yaml:"rules"`
+	// Label value of the branches matching nothing, as-is if empty
+	Default string `yaml:"default"`
+}
+
+type BranchRule struct {
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+}
+
+// CustomLabel is a label computed with a Go template, and optionally a regex, over the run and test fields.
+type CustomLabel struct {
+	Name        string   `yaml:"name"`
+	Template    string   `yaml:"template"`
+	Regex       string   `yaml:"regex"`
+	Replacement string   `yaml:"replacement"`
+	Default     string   `yaml:"default"`
+	Families    []string `yaml:"families"`
+}
+
+type AuthorLabel struct {
+	// One of raw, hash, domain or mapping. Disabled if empty.
+	Mode        string `yaml:"mode"`
+	Salt        string `yaml:"salt"`
+	MappingFile string `yaml:"mappingFile"`
+}
+
+type Retention struct {
+	// Time to keep in memory the results of a test/run before removing it
+	KeepUntil time.Duration `yaml:"keepUntil"`
+	// Retention of the run, test and spec families
+	Families map[string]FamilyRetention `yaml:"families"`
+}
+
+type FamilyRetention struct {
+	// Defaults to retention.keepUntil
+	KeepUntil time.Duration `yaml:"keepUntil"`
+	// Maximum number of entries of each map of the family, unlimited if 0
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+type Outputs struct {
+	// One of current, legacy or both
+	MetricNames   string        `yaml:"metricNames"`
+	OpenMetrics   bool          `yaml:"openMetrics"`
+	DashboardURL  string        `yaml:"dashboardURL"`
+	RunTimestamps RunTimestamps `yaml:"runTimestamps"`
+	Histograms    Histograms    `yaml:"histograms"`
+	Aggregations  Aggregations  `yaml:"aggregations"`
+}
+
+type RunTimestamps struct {
+	Enabled bool          `yaml:"enabled"`
+	MaxAge  time.Duration `yaml:"maxAge"`
+}
+
+type Histograms struct {
+	TestDurationBuckets []float64 `yaml:"testDurationBuckets"`
+	RunDurationBuckets  []float64 `yaml:"runDurationBuckets"`
+	NativeBucketFactor  float64   `yaml:"nativeBucketFactor"`
+}
+
+type Aggregations struct {
+	// Weight of the latest duration in the moving average, disabled if 0
+	TestDurationEWMA float64   `yaml:"testDurationEWMA"`
+	RunDurationMax   bool      `yaml:"runDurationMax"`
+	Flakiness        Flakiness `yaml:"flakiness"`
+}
+
+type Flakiness struct {
+	// Only the runs of this branch are counted, all the branches if empty
+	Branch string        `yaml:"branch"`
+	Runs   int           `yaml:"runs"`
+	Window time.Duration `yaml:"window"`
+}
+
+// Default returns the configuration used for the fields missing from the file.
+func Default() Config {
+	return Config{
+		Credentials: map[string]Credentials{},
+		Polling: Polling{
+			Interval:           time.Minute,
+			Backlog:            cypresscollector.DefaultBacklog,
+			ReadinessThreshold: DefaultReadinessThreshold,
+		},
+		Retention: Retention{
+			KeepUntil: 14 * 24 * time.Hour,
+		},
+		Modules: map[string]Module{},
+		Probe: Probe{
+			IdleTimeout: time.Hour,
+			MaxTargets:  DefaultProbeMaxTargets,
+		},
+		Outputs: Outputs{
+			MetricNames:  string(cypresscollector.MetricNamesCurrent),
+			OpenMetrics:  true,
+			DashboardURL: cypresscollector.DefaultDashboardURL,
+			RunTimestamps: RunTimestamps{
+				MaxAge: cypresscollector.DefaultTimestampMaxAge,
+			},
+		},
+	}
+}
+
+// ValidationError lists all the problems found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration :\n  %v", strings.Join(e.Problems, "\n  "))
+}
+
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf("%v: %v", field, fmt.Sprintf(format, args...)))
+}
+
+// Load reads and validates a configuration file.
+func Load(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(content)
+}
+
+// Parse decodes and validates a configuration. Unknown fields are rejected, to catch typos.
+func Parse(content []byte) (*Config, error) {
+	c := Default()
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil {
+		return nil, fmt.Errorf("invalid configuration : %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate checks the configuration, and returns all the problems found with the path of the faulty field.
+func (c *Config) Validate() error {
+	errs := &ValidationError{}
+
+	names := []string{}
+	for name := range c.Credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		creds := c.Credentials[name]
+		field := fmt.Sprintf("credentials.%v", name)
+		if creds.Email == "" {
+			errs.add(field+".email", "is required")
+		}
+		if _, err := creds.password(); err != nil {
+			errs.add(field, "%v", err)
+		}
+	}
+
+	if len(c.Projects) == 0 && len(c.Modules) == 0 {
+		errs.add("projects", "at least one project, or one module to probe projects, is required")
+	}
+	seen := map[string]bool{}
+	for i, p := range c.Projects {
+		field := fmt.Sprintf("projects[%v]", i)
+		if p.ID == "" {
+			errs.add(field+".id", "is required")
+		} else if seen[p.ID] {
+			errs.add(field+".id", "project %q is already defined", p.ID)
+		}
+		seen[p.ID] = true
+		if _, ok := c.Credentials[p.Credentials]; !ok && p.Credentials != "" {
+			errs.add(field+".credentials", "unknown credentials %q", p.Credentials)
+		}
+	}
+
+	modules := []string{}
+	for name := range c.Modules {
+		modules = append(modules, name)
+	}
+	sort.Strings(modules)
+	for _, name := range modules {
+		module := c.Modules[name]
+		field := fmt.Sprintf("modules.%v", name)
+		if _, ok := c.Credentials[module.Credentials]; !ok && module.Credentials != "" {
+			errs.add(field+".credentials", "unknown credentials %q", module.Credentials)
+		}
+		if module.Labels != nil {
+			if _, err := c.collectorOptions(*module.Labels, field+".labels"); err != nil {
+				errs.add(err.field, "%v", err.err)
+			}
+		}
+	}
+	if c.Probe.IdleTimeout <= 0 {
+		errs.add("probe.idleTimeout", "must be positive, got %v", c.Probe.IdleTimeout)
+	}
+	if c.Probe.MaxTargets <= 0 {
+		errs.add("probe.maxTargets", "must be positive, got %v", c.Probe.MaxTargets)
+	}
+
+	if c.Polling.Interval <= 0 {
+		errs.add("polling.interval", "must be positive, got %v", c.Polling.Interval)
+	}
+	if c.Polling.Backlog <= 0 {
+		errs.add("polling.backlog", "must be positive, got %v", c.Polling.Backlog)
+	}
+	if c.Polling.ReadinessThreshold <= c.Polling.Interval {
+		errs.add("polling.readinessThreshold", "must be greater than polling.interval ( %v ), got %v", c.Polling.Interval, c.Polling.ReadinessThreshold)
+	}
+
+	if c.Retention.KeepUntil <= 0 {
+		errs.add("retention.keepUntil", "must be positive, got %v", c.Retention.KeepUntil)
+	}
+
+	if _, err := url.ParseRequestURI(c.Outputs.DashboardURL); err != nil {
+		errs.add("outputs.dashboardURL", "%v", err)
+	}
+	if c.Outputs.RunTimestamps.MaxAge <= 0 {
+		errs.add("outputs.runTimestamps.maxAge", "must be positive, got %v", c.Outputs.RunTimestamps.MaxAge)
+	}
+
+	if _, err := c.collectorOptions(c.Labels, "labels"); err != nil {
+		errs.add(err.field, "%v", err.err)
+	}
+
+	if len(errs.Problems) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// password returns the password of the credentials, read from the file or the environment if needed.
+func (c Credentials) password() (string, error) {
+	sources := 0
+	for _, s := range []string{c.Password, c.PasswordFile, c.PasswordEnv} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", fmt.Errorf("only one of password, passwordFile or passwordEnv can be set")
+	}
+	switch {
+	case c.PasswordFile != "":
+		content, err := os.ReadFile(c.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("can't read passwordFile : %v", err)
+		}
+		return strings.TrimSpace(string(content)), nil
+	case c.PasswordEnv != "":
+		password, ok := os.LookupEnv(c.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %v of passwordEnv isn't set", c.PasswordEnv)
+		}
+		return password, nil
+	}
+	return c.Password, nil
+}
+
+// ProjectCredentials returns the email and password used by a project.
+func (c *Config) ProjectCredentials(p Project) (string, string, error) {
+	email, password, err := c.credentials(p.Credentials)
+	if err != nil {
+		return "", "", fmt.Errorf("project %v : %v", p.ID, err)
+	}
+	return email, password, nil
+}
+
+func (c *Config) credentials(name string) (string, string, error) {
+	if name == "" {
+		return "", "", nil
+	}
+	creds, ok := c.Credentials[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown credentials %q", name)
+	}
+	password, err := creds.password()
+	if err != nil {
+		return "", "", err
+	}
+	return creds.Email, password, nil
+}
+
+// CollectorOptions returns the options of the collectors, loading the files they reference.
+func (c *Config) CollectorOptions() (cypresscollector.CollectorOptions, error) {
+	opts, err := c.collectorOptions(c.Labels, "labels")
+	if err != nil {
+		return opts, fmt.Errorf("%v: %v", err.field, err.err)
+	}
+	return opts, nil
+}
+
+type fieldError struct {
+	field string
+	err   error
+}
+
+// ModuleSettings returns the credentials and the options of the collectors of a module.
+func (c *Config) ModuleSettings(name string) (string, string, cypresscollector.CollectorOptions, error) {
+	module, ok := c.Modules[name]
+	if !ok && name != DefaultModule {
+		return "", "", cypresscollector.CollectorOptions{}, fmt.Errorf("unknown module %q", name)
+	}
+	labels := c.Labels
+	if module.Labels != nil {
+		labels = *module.Labels
+	}
+	opts, fieldErr := c.collectorOptions(labels, fmt.Sprintf("modules.%v.labels", name))
+	if fieldErr != nil {
+		return "", "", opts, fmt.Errorf("%v: %v", fieldErr.field, fieldErr.err)
+	}
+	email, password, err := c.credentials(module.Credentials)
+	if err != nil {
+		return "", "", opts, fmt.Errorf("module %v : %v", name, err)
+	}
+	return email, password, opts, nil
+}
+
+func (c *Config) collectorOptions(labels Labels, labelsField string) (cypresscollector.CollectorOptions, *fieldError) {
+	opts := cypresscollector.CollectorOptions{
+		DashboardURL: c.Outputs.DashboardURL,
+		Backlog:      c.Polling.Backlog,
+		Timestamps: cypresscollector.TimestampOptions{
+			Enabled: c.Outputs.RunTimestamps.Enabled,
+			MaxAge:  c.Outputs.RunTimestamps.MaxAge,
+		},
+		Histograms: cypresscollector.HistogramOptions{
+			TestDurationBuckets: c.Outputs.Histograms.TestDurationBuckets,
+			RunDurationBuckets:  c.Outputs.Histograms.RunDurationBuckets,
+			NativeBucketFactor:  c.Outputs.Histograms.NativeBucketFactor,
+		},
+		Aggregations: cypresscollector.AggregationOptions{
+			TestDurationEWMA: c.Outputs.Aggregations.TestDurationEWMA,
+			RunDurationMax:   c.Outputs.Aggregations.RunDurationMax,
+			Flakiness: cypresscollector.FlakinessOptions{
+				Branch: c.Outputs.Aggregations.Flakiness.Branch,
+				Runs:   c.Outputs.Aggregations.Flakiness.Runs,
+				Window: c.Outputs.Aggregations.Flakiness.Window,
+			},
+		},
+	}
+	if c.Retention.Families != nil {
+		opts.Retention = cypresscollector.RetentionOptions{}
+		for family, r := range c.Retention.Families {
+			opts.Retention[cypresscollector.LabelFamily(family)] = cypresscollector.FamilyRetention{KeepUntil: r.KeepUntil, MaxEntries: r.MaxEntries}
+		}
+		if err := opts.Retention.Validate(); err != nil {
+			return opts, &fieldError{"retention.families", err}
+		}
+	}
+	var err error
+	opts.MetricNames, err = cypresscollector.ParseMetricNamesMode(c.Outputs.MetricNames)
+	if err != nil {
+		return opts, &fieldError{"outputs.metricNames", err}
+	}
+	if err := opts.Histograms.Validate(); err != nil {
+		return opts, &fieldError{"outputs.histograms", err}
+	}
+	if err := opts.Aggregations.Validate(); err != nil {
+		return opts, &fieldError{"outputs.aggregations", err}
+	}
+
+	opts.Author.Mode, err = cypresscollector.ParseAuthorLabelMode(labels.Author.Mode)
+	if err != nil {
+		return opts, &fieldError{labelsField + ".author.mode", err}
+	}
+	opts.Author.Salt = labels.Author.Salt
+	if labels.Author.MappingFile != "" {
+		opts.Author.Mapping, err = cypresscollector.LoadAuthorMapping(labels.Author.MappingFile)
+		if err != nil {
+			return opts, &fieldError{labelsField + ".author.mappingFile", err}
+		}
+	}
+	if err := opts.Author.Validate(); err != nil {
+		return opts, &fieldError{labelsField + ".author", err}
+	}
+
+	if labels.Families != nil {
+		opts.Labels.Families = map[cypresscollector.LabelFamily][]string{}
+		for family, names := range labels.Families {
+			opts.Labels.Families[cypresscollector.LabelFamily(family)] = names
+		}
+		if err := (cypresscollector.LabelOptions{Families: opts.Labels.Families}).Validate(); err != nil {
+			return opts, &fieldError{labelsField + ".families", err}
+		}
+	}
+	if labels.Limits != nil {
+		opts.Labels.Limits = map[cypresscollector.LabelFamily]int{}
+		for family, limit := range labels.Limits {
+			opts.Labels.Limits[cypresscollector.LabelFamily(family)] = limit
+		}
+		if err := (cypresscollector.LabelOptions{Limits: opts.Labels.Limits}).Validate(); err != nil {
+			return opts, &fieldError{labelsField + ".limits", err}
+		}
+	}
+
+	opts.Labels.Branches = cypresscollector.BranchOptions{
+		Keep:    labels.Branches.Keep,
+		Default: labels.Branches.Default,
+	}
+	for _, rule := range labels.Branches.Rules {
+		opts.Labels.Branches.Rules = append(opts.Labels.Branches.Rules, cypresscollector.BranchRule{Regex: rule.Regex, Replacement: rule.Replacement})
+	}
+	if err := opts.Labels.Branches.Validate(); err != nil {
+		return opts, &fieldError{labelsField + ".branches", err}
+	}
+
+	for _, custom := range labels.Custom {
+		label := cypresscollector.CustomLabel{
+			Name:        custom.Name,
+			Template:    custom.Template,
+			Regex:       custom.Regex,
+			Replacement: custom.Replacement,
+			Default:     custom.Default,
+		}
+		for _, family := range custom.Families {
+			label.Families = append(label.Families, cypresscollector.LabelFamily(family))
+		}
+		opts.Labels.Custom = append(opts.Labels.Custom, label)
+	}
+	if err := opts.Labels.Validate(); err != nil {
+		return opts, &fieldError{labelsField + ".custom", err}
+	}
+	return opts, nil
+}
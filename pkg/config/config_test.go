@@ -0,0 +1,255 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		// Substrings expected in the error, no error expected if empty
+		wantErrs []string
+	}{
+		{
+			name: "Should apply the defaults",
+			content: `
+projects:
+  - id: 7s5okt
+`,
+		},
+		{
+			name: "Should accept a complete configuration",
+			content: `
+credentials:
+  ci:
+    email: ci@example.com
+    password: secret
+projects:
+  - id: 7s5okt
+    credentials: ci
+polling:
+  interval: 30s
+  backlog: 10
+  readinessThreshold: 5m
+labels:
+  author:
+    mode: hash
+    salt: pepper
+retention:
+  keepUntil: 72h
+outputs:
+  metricNames: both
+  openMetrics: false
+  runTimestamps:
+    enabled: true
+    maxAge: 30m
+  histograms:
+    testDurationBuckets: [0.1, 1, 10]
+  aggregations:
+    testDurationEWMA: 0.3
+    runDurationMax: true
+    flakiness:
+      branch: main
+      runs: 20
+      window: 168h
+`,
+		},
+		{
+			name:     "Should reject unknown fields",
+			content:  "projects:\n  - id: 7s5okt\n    credential: ci\n",
+			wantErrs: []string{"line 3: field credential not found"},
+		},
+		{
+			name:     "Should reject invalid durations",
+			content:  "projects:\n  - id: 7s5okt\npolling:\n  interval: often\n",
+			wantErrs: []string{"line 4"},
+		},
+		{
+			name:     "Should require probe targets",
+			content:  "projects:\n  - id: 7s5okt\nprobe:\n  maxTargets: 0\n",
+			wantErrs: []string{"probe.maxTargets: must be positive, got 0"},
+		},
+		{
+			name:     "Should require a project",
+			content:  "polling:\n  interval: 1m\n",
+			wantErrs: []string{"projects: at least one project, or one module to probe projects, is required"},
+		},
+		{
+			name: "Should report all the problems with their field",
+			content: `
+credentials:
+  ci:
+    password: secret
+    passwordEnv: CYPRESS_EXPORTER_TEST_UNSET
+projects:
+  - id: 7s5okt
+    credentials: ci
+  - id: 7s5okt
+    credentials: unknown
+polling:
+  interval: 10m
+labels:
+  author:
+    mode: nickname
+outputs:
+  histograms:
+    runDurationBuckets: [10, 1]
+`,
+			wantErrs: []string{
+				"credentials.ci.email: is required",
+				"credentials.ci: only one of password, passwordFile or passwordEnv can be set",
+				`projects[1].id: project "7s5okt" is already defined`,
+				`projects[1].credentials: unknown credentials "unknown"`,
+				"polling.readinessThreshold: must be greater than polling.interval",
+				"outputs.histograms: buckets must be in strictly increasing order",
+			},
+		},
+		{
+			name:     "Should reject an invalid moving average weight",
+			content:  "projects:\n  - id: 7s5okt\noutputs:\n  aggregations:\n    testDurationEWMA: 2\n",
+			wantErrs: []string{"outputs.aggregations: the weight of the test duration EWMA must be between 0 and 1"},
+		},
+		{
+			name:     "Should reject a flakiness without runs nor window",
+			content:  "projects:\n  - id: 7s5okt\noutputs:\n  aggregations:\n    flakiness:\n      branch: main\n",
+			wantErrs: []string{"outputs.aggregations: the flakiness of branch main requires a number of runs or a window"},
+		},
+		{
+			name: "Should accept modules without projects",
+			content: `
+credentials:
+  ci:
+    email: ci@example.com
+    password: secret
+modules:
+  team:
+    credentials: ci
+    labels:
+      author:
+        mode: domain
+`,
+		},
+		{
+			name:     "Should validate the modules",
+			content:  "modules:\n  team:\n    credentials: unknown\n    labels:\n      author:\n        mode: nickname\n",
+			wantErrs: []string{`modules.team.credentials: unknown credentials "unknown"`, "modules.team.labels.author.mode: unknown author label mode"},
+		},
+		{
+			name:     "Should validate the author label",
+			content:  "projects:\n  - id: 7s5okt\nlabels:\n  author:\n    mode: hash\n",
+			wantErrs: []string{`labels.author: author label mode "hash" requires a salt`},
+		},
+		{
+			name:    "Should parse the label families",
+			content: "projects:\n  - id: 7s5okt\nlabels:\n  families:\n    test: [project_id, spec_file, name]\n",
+		},
+		{
+			name:     "Should validate the label families",
+			content:  "projects:\n  - id: 7s5okt\nlabels:\n  families:\n    run: [project_id, name]\n",
+			wantErrs: []string{`labels.families: label "name" can't be used in family run`},
+		},
+		{
+			name:     "Should validate the branch rules",
+			content:  "projects:\n  - id: 7s5okt\nlabels:\n  branches:\n    keep: [main, 'release/.*']\n    rules:\n      - regex: 'feat/.*'\n    default: other\n",
+			wantErrs: []string{`labels.branches: branch rule "feat/.*" requires a replacement`},
+		},
+		{
+			name:     "Should validate the limits",
+			content:  "projects:\n  - id: 7s5okt\nlabels:\n  limits:\n    test: 10000\n    overhead: 100\n",
+			wantErrs: []string{`labels.limits: the label combinations of family "overhead" can't be limited`},
+		},
+		{
+			name:    "Should parse the retention of the families",
+			content: "projects:\n  - id: 7s5okt\nretention:\n  families:\n    test:\n      keepUntil: 24h\n      maxEntries: 100000\n",
+		},
+		{
+			name:     "Should validate the retention of the families",
+			content:  "projects:\n  - id: 7s5okt\nretention:\n  families:\n    project:\n      keepUntil: 24h\n",
+			wantErrs: []string{`retention.families: the retention of family "project" can't be changed`},
+		},
+		{
+			name:    "Should parse the custom labels",
+			content: "projects:\n  - id: 7s5okt\nlabels:\n  custom:\n    - name: team\n      template: '{{ .Test.Instance.Spec.ShortPath }}'\n      regex: '^cypress/e2e/([^/]+)/'\n      families: [test, spec]\n",
+		},
+		{
+			name:     "Should validate the custom labels",
+			content:  "projects:\n  - id: 7s5okt\nlabels:\n  custom:\n    - name: team\n      template: '{{ .Test.Instance.Spec.ShortPath }}'\n      families: [run]\n",
+			wantErrs: []string{`labels.custom: template of label "team" can't be used in family run`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.content))
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Errorf("Parse() error = %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Parse() should fail with %v", tt.wantErrs)
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Parse() error = %v, should contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParse_defaults(t *testing.T) {
+	c, err := Parse([]byte("projects:\n  - id: 7s5okt\npolling:\n  interval: 2m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Polling.Interval != 2*time.Minute || c.Polling.ReadinessThreshold != DefaultReadinessThreshold || !c.Outputs.OpenMetrics {
+		t.Errorf("Parse() = %+v, should keep the defaults of the missing fields", c)
+	}
+}
+
+func TestConfig_ProjectCredentials(t *testing.T) {
+	t.Setenv("CYPRESS_EXPORTER_TEST_PASSWORD", "from-env")
+	c := Default()
+	c.Credentials["ci"] = Credentials{Email: "ci@example.com", PasswordEnv: "CYPRESS_EXPORTER_TEST_PASSWORD"}
+
+	email, password, err := c.ProjectCredentials(Project{ID: "7s5okt", Credentials: "ci"})
+	if err != nil || email != "ci@example.com" || password != "from-env" {
+		t.Errorf("Config.ProjectCredentials() = %v, %v, %v", email, password, err)
+	}
+
+	email, password, err = c.ProjectCredentials(Project{ID: "public"})
+	if err != nil || email != "" || password != "" {
+		t.Errorf("Config.ProjectCredentials() of a public project = %v, %v, %v", email, password, err)
+	}
+
+	_, _, err = c.ProjectCredentials(Project{ID: "7s5okt", Credentials: "unknown"})
+	if err == nil {
+		t.Errorf("Config.ProjectCredentials() with unknown credentials error = %v", err)
+	}
+}
+
+func TestConfig_ModuleSettings(t *testing.T) {
+	c := Default()
+	c.Labels.Author.Mode = "domain"
+	c.Credentials["ci"] = Credentials{Email: "ci@example.com", Password: "secret"}
+	c.Modules["team"] = Module{Credentials: "ci", Labels: &Labels{Author: AuthorLabel{Mode: "raw"}}}
+
+	email, _, opts, err := c.ModuleSettings("team")
+	if err != nil || email != "ci@example.com" || opts.Author.Mode != "raw" {
+		t.Errorf("Config.ModuleSettings(team) = %v, %+v, %v", email, opts.Author, err)
+	}
+
+	// The default module exists even if it's not configured, with the global labels
+	email, _, opts, err = c.ModuleSettings(DefaultModule)
+	if err != nil || email != "" || opts.Author.Mode != "domain" {
+		t.Errorf("Config.ModuleSettings(default) = %v, %+v, %v", email, opts.Author, err)
+	}
+
+	if _, _, _, err := c.ModuleSettings("unknown"); err == nil {
+		t.Errorf("Config.ModuleSettings(unknown) should fail")
+	}
+}
@@ -2,13 +2,16 @@ package cypressclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/optional"
 	"github.com/sirupsen/logrus"
 )
@@ -60,12 +63,17 @@ type RunResult struct {
 	TotalPending            int         `json:"totalPending"`
 	TotalSkipped            int         `json:"totalSkipped"`
 	TotalMutedTests         int         `json:"totalMutedTests"`
+	CreatedAt               time.Time   `json:"createdAt"`
 	StartTime               time.Time   `json:"startTime"`
+	CompletedAt             time.Time   `json:"completedAt"`
 	TotalDuration           int         `json:"totalDuration"`
 	ScheduledToCompleteAt   time.Time   `json:"scheduledToCompleteAt"`
 	ParallelizationDisabled bool        `json:"parallelizationDisabled"`
 	CancelledAt             interface{} `json:"cancelledAt"`
 	TotalFlakyTests         int         `json:"totalFlakyTests"`
+	CypressVersion          string      `json:"cypressVersion"`
+	TestingType             string      `json:"testingType"`
+	Tags                    []RunTag    `json:"tags"`
 	Project                 struct {
 		ID string `json:"id"`
 	} `json:"project"`
@@ -84,6 +92,11 @@ type RunResult struct {
 	} `json:"testResults"`
 }
 
+type RunTag struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
 type TestResult struct {
 	ID         string   `json:"id"`
 	TitleParts []string `json:"titleParts"`
@@ -95,7 +108,9 @@ type TestResult struct {
 		ID          string    `json:"id"`
 		Status      string    `json:"status"`
 		Duration    int       `json:"duration"`
+		StartedAt   time.Time `json:"startedAt"`
 		CompletedAt time.Time `json:"completedAt"`
+		MachineID   string    `json:"machineId"`
 		Os          struct {
 			Name    string `json:"name"`
 			Version string `json:"version"`
@@ -119,27 +134,77 @@ type CypressDashboardMetricsClient struct {
 	httpClient *http.Client
 	endpoint   url.URL
 
+	// Protects the credentials and the token, which can be changed while the client is used
+	credentialsMu       sync.Mutex
 	email               string
 	password            string
 	authenticationToken string
+
+	// Called after each authentication to the dashboard, with its error if any
+	onAuthenticate func(error)
+	// End of the requested time range when the options don't give one
+	clock clock.Clock
+}
+
+// SetClock changes the clock giving the default end of the requested time range.
+func (cli *CypressDashboardMetricsClient) SetClock(c clock.Clock) {
+	cli.clock = clock.OrReal(c)
+}
+
+// Instrument allows to observe the GraphQL requests made to the dashboard, by wrapping the transport of the http
+// client, and the authentications.
+func (cli *CypressDashboardMetricsClient) Instrument(wrap func(http.RoundTripper) http.RoundTripper, onAuthenticate func(error)) {
+	transport := cli.httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	cli.httpClient.Transport = wrap(transport)
+	cli.onAuthenticate = onAuthenticate
+}
+
+func (cli *CypressDashboardMetricsClient) Authenticate(ctx context.Context) error {
+	err := cli.authenticate(ctx)
+	if cli.onAuthenticate != nil {
+		cli.onAuthenticate(err)
+	}
+	if err != nil {
+		return newRequestError(AuthenticationError, err)
+	}
+	return nil
+}
+
+// SetCredentials changes the credentials used by the next authentication.
+func (cli *CypressDashboardMetricsClient) SetCredentials(email, password string) {
+	cli.credentialsMu.Lock()
+	defer cli.credentialsMu.Unlock()
+	cli.email = email
+	cli.password = password
 }
 
-func (cli *CypressDashboardMetricsClient) Authenticate() error {
+func (cli *CypressDashboardMetricsClient) authenticate(ctx context.Context) error {
 
+	cli.credentialsMu.Lock()
 	body := map[string]string{
 		"email":    cli.email,
 		"password": cli.password,
 	}
+	cli.credentialsMu.Unlock()
 
 	content, err := json.Marshal(body)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post("https://authenticate.cypress.io/login/local?source=dashboard", "application/json", bytes.NewBuffer(content))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://authenticate.cypress.io/login/local?source=dashboard", bytes.NewBuffer(content))
 	if err != nil {
 		return err
 	}
+	req.Header.Set("content-type", "application/json")
+	resp, err := cli.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
 	logrus.Debugln("Header results of the request to authentication", resp.Header)
 
@@ -147,18 +212,28 @@ func (cli *CypressDashboardMetricsClient) Authenticate() error {
 	token := strings.ReplaceAll(strings.Split(cookie, ";")[0], "cy_dashboard=", "")
 
 	logrus.Debugln("Extracted token : ", token)
+	cli.credentialsMu.Lock()
 	cli.authenticationToken = token
+	cli.credentialsMu.Unlock()
 	return nil
 }
 
-func NewCypressDashboardMetricsClient(endpoint url.URL, email, password string) CypressDashboardMetricsClient {
+// token returns the token of the last authentication.
+func (cli *CypressDashboardMetricsClient) token() string {
+	cli.credentialsMu.Lock()
+	defer cli.credentialsMu.Unlock()
+	return cli.authenticationToken
+}
 
-	client := CypressDashboardMetricsClient{
-		httpClient:          http.DefaultClient,
+func NewCypressDashboardMetricsClient(endpoint url.URL, email, password string) *CypressDashboardMetricsClient {
+
+	client := &CypressDashboardMetricsClient{
+		httpClient:          &http.Client{},
 		endpoint:            endpoint,
 		email:               email,
 		password:            password,
 		authenticationToken: ``,
+		clock:               clock.Real,
 	}
 
 	client.httpClient.Timeout = defaultTimeout
@@ -169,40 +244,42 @@ func NewCypressDashboardMetricsClient(endpoint url.URL, email, password string)
 // GetMetrics returns a list of StatsFromCypressDashboard, or an error
 
 type GetMetricOptions struct {
-	From    optional.OptionalTime
-	To      optional.OptionalTime
-	Size    optional.OptionalInt
+	From    optional.Option[time.Time]
+	To      optional.Option[time.Time]
+	Size    optional.Option[int]
 	Project string
 }
 
+// EmptyMetricOptions returns the options of the latest runs. The time range ends when the request is made, according
+// to the clock of the client.
 func EmptyMetricOptions() GetMetricOptions {
 	from := time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)
-	to := time.Now()
 	defaultPaging := 3
 	return GetMetricOptions{
-		From:    optional.NewOptionalTime(&from),
-		To:      optional.NewOptionalTime(&to),
-		Size:    optional.NewOptionalInt(&defaultPaging),
+		From:    optional.Some(from),
+		To:      optional.None[time.Time](),
+		Size:    optional.Some(defaultPaging),
 		Project: "7s5okt", // This is the realworld example from Cypress
 	}
 }
 
-func (client *CypressDashboardMetricsClient) GetMetrics(opts GetMetricOptions) (*StatsFromCypressDashboard, error) {
+// GetMetrics fetches the latest runs of a project. The requests are canceled when the context is done.
+func (client *CypressDashboardMetricsClient) GetMetrics(ctx context.Context, opts GetMetricOptions) (*StatsFromCypressDashboard, error) {
 
 	statsURL := client.endpoint
 
 	createReq := func() (*http.Request, error) {
-		body, err := createMetricRequest(opts.Project, optional.OrElseTime(opts.From, time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)),
-			optional.OrElseTime(opts.To, time.Now()),
-			optional.OrElseInt(opts.Size, defaultPaging))
+		body, err := createMetricRequest(opts.Project, opts.From.OrElse(time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC)),
+			opts.To.OrElse(client.clock.Now()),
+			opts.Size.OrElse(defaultPaging))
 		if err != nil {
 			return nil, err
 		}
-		req, err := http.NewRequest(http.MethodPost, statsURL.String(), body)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, statsURL.String(), body)
 		if err != nil {
 			return nil, err
 		}
-		req.Header.Add("cookie", fmt.Sprintf("cy_dashboard=%v", client.authenticationToken))
+		req.Header.Add("cookie", fmt.Sprintf("cy_dashboard=%v", client.token()))
 		req.Header.Add("content-type", "application/json")
 		return req, nil
 	}
@@ -210,12 +287,13 @@ func (client *CypressDashboardMetricsClient) GetMetrics(opts GetMetricOptions) (
 	getAnswer := func(req *http.Request) (*StatsFromCypressDashboard, error) {
 		resp, err := client.httpClient.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, newRequestError(NetworkError, err)
 		}
+		defer resp.Body.Close()
 		stats := StatsFromCypressDashboard{}
 		err = json.NewDecoder(resp.Body).Decode(&stats)
 		if err != nil {
-			return nil, err
+			return nil, newRequestError(DecodeError, err)
 		}
 		return &stats, nil
 	}
@@ -234,7 +312,9 @@ func (client *CypressDashboardMetricsClient) GetMetrics(opts GetMetricOptions) (
 	//  then we'll return a proper error.
 	if len(resp.Errors) > 0 {
 		logrus.Warnf("error on first request, trying to authenticate. Error was : %v", resp.Errors)
-		err := client.Authenticate()
+		if err := client.Authenticate(ctx); err != nil {
+			return nil, err
+		}
 
 		req2, err := createReq()
 		if err != nil {
@@ -243,10 +323,10 @@ func (client *CypressDashboardMetricsClient) GetMetrics(opts GetMetricOptions) (
 
 		resp2, err2 := getAnswer(req2)
 		if err2 != nil {
-			return nil, err
+			return nil, err2
 		}
 		if len(resp2.Errors) > 0 {
-			return nil, fmt.Errorf("Unrecoverable error occured : %v . Check your credentials and project ID.", resp2.Errors)
+			return nil, newRequestError(GraphQLError, fmt.Errorf("Unrecoverable error occured : %v . Check your credentials and project ID.", resp2.Errors))
 		}
 		return resp2, nil
 	}
@@ -1,8 +1,17 @@
 package cypressclient
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
 )
 
 func TestRunResults_Reverse(t *testing.T) {
@@ -57,3 +66,24 @@ func TestRunResults_Reverse(t *testing.T) {
 		})
 	}
 }
+
+func TestCypressDashboardMetricsClient_GetMetrics_defaultTimeRange(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+		w.Write([]byte(`{"data": {}}`))
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	cli := NewCypressDashboardMetricsClient(*endpoint, "", "")
+	now := time.Date(2023, 3, 14, 15, 9, 26, 0, time.UTC)
+	cli.SetClock(clock.NewFake(now))
+	if _, err := cli.GetMetrics(context.Background(), EmptyMetricOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if body := <-received; !strings.Contains(body, `"endDate":"`+now.Format(cypressDateFormat)+`"`) {
+		t.Errorf("GetMetrics() requested %v, want the time range to end at %v", body, now)
+	}
+}
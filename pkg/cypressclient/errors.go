@@ -0,0 +1,34 @@
+package cypressclient
+
+import "fmt"
+
+// ErrorType classifies the errors returned by the client, so that they can be monitored.
+type ErrorType string
+
+const (
+	// The dashboard couldn't be reached
+	NetworkError ErrorType = "network"
+	// The dashboard answered something that isn't the expected JSON
+	DecodeError ErrorType = "decode"
+	// Logging in to the dashboard failed
+	AuthenticationError ErrorType = "authentication"
+	// The GraphQL query returned errors, even after authenticating again
+	GraphQLError ErrorType = "graphql"
+)
+
+type RequestError struct {
+	Type ErrorType
+	Err  error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%v error : %v", e.Type, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+func newRequestError(t ErrorType, err error) error {
+	return &RequestError{t, err}
+}
@@ -58,12 +58,20 @@ func createMetricRequest(projectID string, from time.Time, to time.Time, size in
 		totalPending
 		totalSkipped
 		totalMutedTests
+		createdAt
 		startTime
+		completedAt
 		totalDuration
 		scheduledToCompleteAt
 		parallelizationDisabled
 		cancelledAt
 		totalFlakyTests
+		cypressVersion
+		testingType
+		tags {
+		  id
+		  name
+		}
 		project {
 		  id
 		}
@@ -107,7 +115,9 @@ func createMetricRequest(projectID string, from time.Time, to time.Time, size in
 		id
 		status
 		duration
+		startedAt
 		completedAt
+		machineId
 		group {
 			id    
 			name
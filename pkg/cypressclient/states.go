@@ -22,3 +22,22 @@ var allValidState []state = []state{
 func AllValidState() []state {
 	return allValidState
 }
+
+// Statuses of a run instance, ie a spec file executed by a machine, which aren't test states
+const Errored state = "ERRORED"
+const TimedOut state = "TIMEDOUT"
+const NoTests state = "NOTESTS"
+
+var allInstanceStatus []state = []state{
+	Passed,
+	Failed,
+	Errored,
+	TimedOut,
+	NoTests,
+	Canceled,
+}
+
+// AllInstanceStatus returns the statuses of a completed run instance. The other ones, eg RUNNING, are OTHER.
+func AllInstanceStatus() []state {
+	return allInstanceStatus
+}
@@ -0,0 +1,53 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+)
+
+// AggregationOptions enables the gauges aggregated over the processed runs, which would otherwise need recording rules.
+type AggregationOptions struct {
+	// Weight of the latest duration in cypress_test_duration_seconds_ewma, between 0 and 1. Disabled if 0.
+	TestDurationEWMA float64
+	// Export cypress_run_duration_seconds_max, the longest run over the retention of the run family
+	RunDurationMax bool
+	// Ratios of flaky and failed results of the tests
+	Flakiness FlakinessOptions
+}
+
+func (o AggregationOptions) Validate() error {
+	if o.TestDurationEWMA < 0 || o.TestDurationEWMA > 1 {
+		return fmt.Errorf("the weight of the test duration EWMA must be between 0 and 1, got %v", o.TestDurationEWMA)
+	}
+	return o.Flakiness.Validate()
+}
+
+// newAggregations creates the aggregated gauges enabled by the options. The window of the run duration max is the
+// retention of the run family when the collector is created.
+func (c *CypressDashboardCollector) newAggregations(opts AggregationOptions, runKeepUntil time.Duration) {
+	if opts.TestDurationEWMA > 0 {
+		c.CypressTestDurationEWMA = c.metricNames.desc("cypress_test_duration_seconds_ewma", "", "Exponentially weighted moving average of the duration of a test", labelsInOrder(c.testLabels), msToSec)
+		c.testDurationEWMA.Aggregation = metricsmap.EWMA(opts.TestDurationEWMA)
+	}
+	if opts.RunDurationMax {
+		c.CypressRunDurationMax = c.metricNames.desc("cypress_run_duration_seconds_max", "", "Duration of the longest processed run over the retention", labelsInOrder(c.runInstanceLabels), msToSec)
+		c.runDurationMax.Aggregation = metricsmap.Max(runKeepUntil)
+	}
+	c.newFlakiness(opts.Flakiness)
+}
+
+func (c *CypressDashboardCollector) processAggregations(metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult) {
+	completedAt := runCompletedAt(run)
+	if c.CypressRunDurationMax != nil {
+		c.runDurationMax.AddAt(c.CypressRunDurationMax, float64(run.TotalDuration), completedAt, evaluateLabels(c.runInstanceLabels, metrics, run)...)
+	}
+	if c.CypressTestDurationEWMA != nil {
+		for _, test := range run.TestResults.Nodes {
+			c.testDurationEWMA.AddAt(c.CypressTestDurationEWMA, float64(test.Duration), completedAt, evaluateLabels(c.testLabels, metrics, testContext{run, test})...)
+		}
+	}
+	c.processFlakiness(metrics, run)
+}
@@ -0,0 +1,77 @@
+package cypresscollector
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+)
+
+func TestAggregationOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    AggregationOptions
+		wantErr bool
+	}{
+		{"Should accept no aggregation", AggregationOptions{}, false},
+		{"Should accept a weight of 1", AggregationOptions{TestDurationEWMA: 1, RunDurationMax: true}, false},
+		{"Should refuse a negative weight", AggregationOptions{TestDurationEWMA: -0.5}, true},
+		{"Should refuse a weight greater than 1", AggregationOptions{TestDurationEWMA: 1.5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("AggregationOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCypressDashboardCollector_processAggregations(t *testing.T) {
+	opts := CollectorOptions{Aggregations: AggregationOptions{TestDurationEWMA: 0.5, RunDurationMax: true}}
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completedAt := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i, durations := range [][2]int{{1000, 100}, {500, 300}} {
+		run := cypressclient.RunResult{TotalDuration: durations[0], CompletedAt: completedAt.Add(time.Duration(i) * time.Minute)}
+		run.TestResults.Nodes = []cypressclient.TestResult{{Duration: durations[1]}}
+		c.processAggregations(cypressclient.StatsFromCypressDashboard{}, run)
+	}
+
+	tests := []struct {
+		name string
+		m    metricsmap.MetricMap[float64]
+		want float64
+	}{
+		{"Longest run", &c.runDurationMax, 1000},
+		{"Moving average of the test", &c.testDurationEWMA, 200},
+	}
+	for _, tt := range tests {
+		values := tt.m.Map()
+		if len(values) != 1 {
+			t.Fatalf("%v : %v entries, want 1", tt.name, len(values))
+		}
+		for _, v := range values {
+			if v.Value != tt.want {
+				t.Errorf("%v = %v, want %v", tt.name, v.Value, tt.want)
+			}
+		}
+	}
+}
+
+func TestCypressDashboardCollector_processAggregations_disabled(t *testing.T) {
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := cypressclient.RunResult{TotalDuration: 1000}
+	run.TestResults.Nodes = []cypressclient.TestResult{{Duration: 100}}
+	c.processAggregations(cypressclient.StatsFromCypressDashboard{}, run)
+	if len(c.runDurationMax.Map()) != 0 || len(c.testDurationEWMA.Map()) != 0 {
+		t.Errorf("Aggregations should be disabled by default")
+	}
+}
@@ -0,0 +1,126 @@
+package cypresscollector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+// AuthorLabelMode defines how the commit author email is exposed in the `author` label.
+// Emails are personal data, so the label is disabled by default and each mode exposes
+// less and less information about the author.
+type AuthorLabelMode string
+
+const (
+	AuthorLabelDisabled AuthorLabelMode = ""
+	AuthorLabelRaw      AuthorLabelMode = "raw"
+	AuthorLabelHash     AuthorLabelMode = "hash"
+	AuthorLabelDomain   AuthorLabelMode = "domain"
+	AuthorLabelMapping  AuthorLabelMode = "mapping"
+)
+
+const (
+	unknownAuthor  = "unknown"
+	unmappedAuthor = "unmapped"
+	// Keep the hash short enough to be readable in grafana, while still avoiding collisions between authors
+	authorHashLength = 16
+)
+
+func ParseAuthorLabelMode(mode string) (AuthorLabelMode, error) {
+	switch m := AuthorLabelMode(strings.ToLower(mode)); m {
+	case AuthorLabelDisabled, AuthorLabelRaw, AuthorLabelHash, AuthorLabelDomain, AuthorLabelMapping:
+		return m, nil
+	}
+	return AuthorLabelDisabled, fmt.Errorf("unknown author label mode %q, expected one of raw, hash, domain or mapping", mode)
+}
+
+// AuthorLabelOptions configures the opt-in `author` label on run metrics.
+type AuthorLabelOptions struct {
+	Mode AuthorLabelMode
+	// Salt used in `hash` mode, so that the hash can't be reversed with a dictionary of known emails
+	Salt string
+	// Mapping from an author email to a team name, used in `mapping` mode
+	Mapping map[string]string
+}
+
+func (o AuthorLabelOptions) Enabled() bool {
+	return o.Mode != AuthorLabelDisabled
+}
+
+func (o AuthorLabelOptions) Validate() error {
+	switch o.Mode {
+	case AuthorLabelHash:
+		if o.Salt == "" {
+			return fmt.Errorf("author label mode %q requires a salt", o.Mode)
+		}
+	case AuthorLabelMapping:
+		if len(o.Mapping) == 0 {
+			return fmt.Errorf("author label mode %q requires a non empty mapping file", o.Mode)
+		}
+	}
+	return nil
+}
+
+// LoadAuthorMapping reads a JSON file mapping author emails to team names, eg :
+// { "jane@example.com": "checkout", "john@example.com": "search" }
+func LoadAuthorMapping(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]string{}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, fmt.Errorf("invalid author mapping file %v : %v", path, err)
+	}
+	// Emails are case insensitive, so normalize them once here
+	mapping := make(map[string]string, len(raw))
+	for email, team := range raw {
+		mapping[normalizeEmail(email)] = team
+	}
+	return mapping, nil
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// authorLabelValue returns the value of the `author` label for the given email, according to the mode.
+func (o AuthorLabelOptions) authorLabelValue(email string) string {
+	email = normalizeEmail(email)
+	if email == "" {
+		return unknownAuthor
+	}
+	switch o.Mode {
+	case AuthorLabelRaw:
+		return email
+	case AuthorLabelHash:
+		sum := sha256.Sum256([]byte(o.Salt + email))
+		return hex.EncodeToString(sum[:])[:authorHashLength]
+	case AuthorLabelDomain:
+		if i := strings.LastIndex(email, "@"); i >= 0 && i < len(email)-1 {
+			return email[i+1:]
+		}
+		return unknownAuthor
+	case AuthorLabelMapping:
+		if team, ok := o.Mapping[email]; ok {
+			return team
+		}
+		return unmappedAuthor
+	}
+	return unknownAuthor
+}
+
+func authorLabel(o AuthorLabelOptions) labelsEvaluatorImpl {
+	return labelsEvaluatorImpl{
+		label: func() string { return "author" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			return o.authorLabelValue(runOf(i).Commit.AuthorEmail)
+		},
+	}
+}
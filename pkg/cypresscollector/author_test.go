@@ -0,0 +1,56 @@
+package cypresscollector
+
+import "testing"
+
+func TestAuthorLabelOptions_authorLabelValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  AuthorLabelOptions
+		email string
+		want  string
+	}{
+		{
+			"Raw mode should keep the normalized email",
+			AuthorLabelOptions{Mode: AuthorLabelRaw},
+			" Jane@Example.com",
+			"jane@example.com",
+		},
+		{
+			"Hash mode should not expose the email",
+			AuthorLabelOptions{Mode: AuthorLabelHash, Salt: "pepper"},
+			"jane@example.com",
+			"2daebb07b6fe2686",
+		},
+		{
+			"Domain mode should only keep the domain",
+			AuthorLabelOptions{Mode: AuthorLabelDomain},
+			"jane@example.com",
+			"example.com",
+		},
+		{
+			"Mapping mode should return the team",
+			AuthorLabelOptions{Mode: AuthorLabelMapping, Mapping: map[string]string{"jane@example.com": "checkout"}},
+			"JANE@example.com",
+			"checkout",
+		},
+		{
+			"Mapping mode should flag unmapped authors",
+			AuthorLabelOptions{Mode: AuthorLabelMapping, Mapping: map[string]string{"jane@example.com": "checkout"}},
+			"john@example.com",
+			unmappedAuthor,
+		},
+		{
+			"Empty email should be unknown",
+			AuthorLabelOptions{Mode: AuthorLabelRaw},
+			"",
+			unknownAuthor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.authorLabelValue(tt.email); got != tt.want {
+				t.Errorf("AuthorLabelOptions.authorLabelValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
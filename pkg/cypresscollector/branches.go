@@ -0,0 +1,103 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+// BranchOptions normalizes the `git_branch` label, so that short lived branches don't each create their own series.
+// Regexes are anchored, like in the relabeling of Prometheus. eg, to keep main and the release branches, and group
+// the other ones :
+//
+//	BranchOptions{
+//		Keep:    []string{"main", "release/.*"},
+//		Rules:   []BranchRule{{Regex: "(feature|feat)/.*", Replacement: "feature"}},
+//		Default: "other",
+//	}
+type BranchOptions struct {
+	// Branches exported as-is
+	Keep []string
+	// Applied in order to the other branches, the first matching rule gives the label value
+	Rules []BranchRule
+	// Label value of the branches matching neither Keep nor Rules. They're exported as-is if empty.
+	Default string
+}
+
+type BranchRule struct {
+	Regex string
+	// Can reference the capture groups of the regex, eg $1
+	Replacement string
+}
+
+func (o BranchOptions) Enabled() bool {
+	return len(o.Keep) > 0 || len(o.Rules) > 0 || o.Default != ""
+}
+
+func (o BranchOptions) Validate() error {
+	_, err := o.normalizer()
+	return err
+}
+
+type branchNormalizer struct {
+	keep     []*regexp.Regexp
+	rules    []*regexp.Regexp
+	replaces []string
+	fallback string
+}
+
+func anchoredRegex(regex string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + regex + ")$")
+}
+
+func (o BranchOptions) normalizer() (*branchNormalizer, error) {
+	n := &branchNormalizer{fallback: o.Default}
+	for _, keep := range o.Keep {
+		r, err := anchoredRegex(keep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch regex %q : %v", keep, err)
+		}
+		n.keep = append(n.keep, r)
+	}
+	for _, rule := range o.Rules {
+		r, err := anchoredRegex(rule.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch rule regex %q : %v", rule.Regex, err)
+		}
+		if rule.Replacement == "" {
+			return nil, fmt.Errorf("branch rule %q requires a replacement", rule.Regex)
+		}
+		n.rules = append(n.rules, r)
+		n.replaces = append(n.replaces, rule.Replacement)
+	}
+	return n, nil
+}
+
+func (n *branchNormalizer) normalize(branch string) string {
+	for _, keep := range n.keep {
+		if keep.MatchString(branch) {
+			return branch
+		}
+	}
+	for i, rule := range n.rules {
+		if match := rule.FindStringSubmatchIndex(branch); match != nil {
+			return string(rule.ExpandString(nil, n.replaces[i], branch, match))
+		}
+	}
+	if n.fallback != "" {
+		return n.fallback
+	}
+	return branch
+}
+
+// branchLabel replaces the `git_branch` label of the registry when the branches are normalized.
+func branchLabel(n *branchNormalizer) labelsEvaluatorImpl {
+	return labelsEvaluatorImpl{
+		label: func() string { return "git_branch" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			return n.normalize(runOf(i).Commit.Branch)
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package cypresscollector
+
+import (
+	"testing"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestBranchNormalizer_normalize(t *testing.T) {
+	opts := BranchOptions{
+		Keep: []string{"main", "release/.*"},
+		Rules: []BranchRule{
+			{Regex: "(feature|feat)/.*", Replacement: "feature"},
+			{Regex: "renovate/([^/]+)/.*", Replacement: "renovate-$1"},
+		},
+		Default: "other",
+	}
+	tests := []struct {
+		name   string
+		opts   BranchOptions
+		branch string
+		want   string
+	}{
+		{"Should keep main", opts, "main", "main"},
+		{"Should keep the release branches", opts, "release/1.2", "release/1.2"},
+		{"Should anchor the regexes", opts, "not-main", "other"},
+		{"Should apply the first matching rule", opts, "feat/login", "feature"},
+		{"Should expand the capture groups", opts, "renovate/npm/lodash-4.x", "renovate-npm"},
+		{"Should group the other branches", opts, "fix-typo", "other"},
+		{"Should keep the other branches without default", BranchOptions{Rules: opts.Rules}, "fix-typo", "fix-typo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := tt.opts.normalizer()
+			if err != nil {
+				t.Fatalf("BranchOptions.normalizer() error = %v", err)
+			}
+			if got := n.normalize(tt.branch); got != tt.want {
+				t.Errorf("branchNormalizer.normalize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBranchOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    BranchOptions
+		wantErr bool
+	}{
+		{"Should accept no rules", BranchOptions{}, false},
+		{"Should refuse an invalid keep regex", BranchOptions{Keep: []string{"release/("}}, true},
+		{"Should refuse an invalid rule regex", BranchOptions{Rules: []BranchRule{{Regex: "(", Replacement: "x"}}}, true},
+		{"Should refuse a rule without replacement", BranchOptions{Rules: []BranchRule{{Regex: "feat/.*"}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("BranchOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLabelOptions_branchesInAllFamilies(t *testing.T) {
+	opts := LabelOptions{Branches: BranchOptions{Keep: []string{"main"}, Default: "other"}}
+	run := cypressclient.RunResult{}
+	run.Commit.Branch = "fix-typo"
+	contexts := map[LabelFamily]interface{}{
+		RunFamily:      run,
+		OverheadFamily: run,
+		TestFamily:     testContext{runResult: run},
+		SpecFamily:     testContext{runResult: run},
+	}
+	for family, ctx := range contexts {
+		evaluators, err := opts.evaluators(family)
+		if err != nil {
+			t.Fatalf("LabelOptions.evaluators(%v) error = %v", family, err)
+		}
+		for _, e := range evaluators {
+			if e.label() == "git_branch" {
+				if got := e.evaluator(cypressclient.StatsFromCypressDashboard{}, ctx); got != "other" {
+					t.Errorf("git_branch of family %v = %v, want other", family, got)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package cypresscollector
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+	"github.com/sirupsen/logrus"
+)
+
+// Number of labels named when a family is over its limit
+const worstLabelsReported = 3
+
+// newBudget returns the budget of a family, keeping the project in its overflow.
+func newBudget(limit int, evaluators []labelsEvaluatorImpl) *metricsmap.Budget {
+	names := labelsInOrder(evaluators)
+	budget := &metricsmap.Budget{Limit: limit, Names: names}
+	for i, name := range names {
+		if name == "project_id" {
+			budget.Keep = append(budget.Keep, i)
+		}
+	}
+	return budget
+}
+
+// reportBudgets counts the label combinations folded into the overflow by the last sync, and logs the labels
+// responsible for them.
+func (c *CypressDashboardCollector) reportBudgets() {
+	families := make([]string, 0, len(c.budgets))
+	for family := range c.budgets {
+		families = append(families, string(family))
+	}
+	sort.Strings(families)
+	for _, family := range families {
+		budget := c.budgets[LabelFamily(family)]
+		dropped := budget.TakeDropped()
+		if dropped == 0 {
+			continue
+		}
+		c.self.droppedCombinations.WithLabelValues(family).Add(float64(dropped))
+		logrus.Warnf("Family %v of project %v reached its limit of %v label combinations, %v new combinations were folded into %v. Labels with the most values : %v",
+			family, c.project, budget.Limit, dropped, metricsmap.Overflow, strings.Join(budget.WorstLabels(worstLabelsReported), ", "))
+	}
+}
@@ -0,0 +1,155 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+	"github.com/sirupsen/logrus"
+)
+
+// CustomLabel is a label defined by the user, from the fields of the project, the run and the test. eg :
+//
+//	CustomLabel{
+//		Name:     "team",
+//		Template: "{{ .Test.Instance.Spec.ShortPath }}",
+//		Regex:    "^cypress/e2e/([^/]+)/",
+//		Default:  "unknown",
+//		Families: []LabelFamily{TestFamily, SpecFamily},
+//	}
+type CustomLabel struct {
+	Name string
+	// Go template evaluated with .Project, .Run and .Test. .Run is nil in the project family, and .Test is nil in the
+	// project, run and overhead families.
+	Template string
+	// If set, the value of the template must match the regex, and is replaced by Replacement. Default is used otherwise.
+	Regex string
+	// Defaults to $1, the first capture group of the regex
+	Replacement string
+	// Value of the label when the regex doesn't match, or the template fails
+	Default string
+	// The label is added after the labels of these families
+	Families []LabelFamily
+}
+
+// labelTemplateData is what the templates of the custom labels are evaluated with.
+type labelTemplateData struct {
+	Project labelTemplateProject
+	Run     *cypressclient.RunResult
+	Test    *cypressclient.TestResult
+}
+
+type labelTemplateProject struct {
+	ID             string
+	Name           string
+	IsUsingRetries bool
+}
+
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Labels that are added by the collector on top of the selected ones
+var reservedLabels = map[string]bool{"state": true, "author": true}
+
+var labelTemplateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"trimPrefix": strings.TrimPrefix,
+	"trimSuffix": strings.TrimSuffix,
+	"replace":    strings.ReplaceAll,
+	"join":       strings.Join,
+}
+
+// labelTemplateSample returns the data of a family with empty fields, used to check the templates at startup.
+func labelTemplateSample(family LabelFamily) labelTemplateData {
+	data := labelTemplateData{}
+	if familyScopes[family] >= runScope {
+		data.Run = &cypressclient.RunResult{}
+	}
+	if familyScopes[family] >= testScope {
+		data.Test = &cypressclient.TestResult{}
+	}
+	return data
+}
+
+func newLabelTemplateData(s cypressclient.StatsFromCypressDashboard, i interface{}) labelTemplateData {
+	data := labelTemplateData{
+		Project: labelTemplateProject{
+			ID:             s.Data.Project.ID,
+			Name:           s.Data.Project.Name,
+			IsUsingRetries: s.Data.Project.IsUsingRetries,
+		},
+	}
+	switch ctx := i.(type) {
+	case cypressclient.RunResult:
+		data.Run = &ctx
+	case testContext:
+		data.Run = &ctx.runResult
+		data.Test = &ctx.testResult
+	}
+	return data
+}
+
+// evaluator compiles the custom label. The template is executed against the families of the label, with empty
+// fields, so that fields which don't exist or aren't available in a family are reported at startup.
+func (l CustomLabel) evaluator() (labelsEvaluatorImpl, error) {
+	if !labelNameRegex.MatchString(l.Name) || strings.HasPrefix(l.Name, "__") {
+		return labelsEvaluatorImpl{}, fmt.Errorf("invalid label name %q", l.Name)
+	}
+	if _, ok := registeredLabel(l.Name); ok || reservedLabels[l.Name] {
+		return labelsEvaluatorImpl{}, fmt.Errorf("label %q already exists", l.Name)
+	}
+	if len(l.Families) == 0 {
+		return labelsEvaluatorImpl{}, fmt.Errorf("label %q isn't added to any family", l.Name)
+	}
+	tmpl, err := template.New(l.Name).Funcs(labelTemplateFuncs).Option("missingkey=error").Parse(l.Template)
+	if err != nil {
+		return labelsEvaluatorImpl{}, fmt.Errorf("invalid template of label %q : %v", l.Name, err)
+	}
+	var regex *regexp.Regexp
+	if l.Regex != "" {
+		if regex, err = regexp.Compile(l.Regex); err != nil {
+			return labelsEvaluatorImpl{}, fmt.Errorf("invalid regex of label %q : %v", l.Name, err)
+		}
+	}
+	replacement := l.Replacement
+	if replacement == "" {
+		replacement = "$1"
+	}
+
+	scope := projectScope
+	for _, family := range l.Families {
+		familyScope, ok := familyScopes[family]
+		if !ok {
+			return labelsEvaluatorImpl{}, fmt.Errorf("unknown label family %q of label %q", family, l.Name)
+		}
+		if err := tmpl.Execute(&strings.Builder{}, labelTemplateSample(family)); err != nil {
+			return labelsEvaluatorImpl{}, fmt.Errorf("template of label %q can't be used in family %v : %v", l.Name, family, err)
+		}
+		if familyScope > scope {
+			scope = familyScope
+		}
+	}
+
+	name := l.Name
+	return labelsEvaluatorImpl{
+		label: func() string { return name },
+		scope: scope,
+		evaluator: func(s cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			value := &strings.Builder{}
+			if err := tmpl.Execute(value, newLabelTemplateData(s, i)); err != nil {
+				logrus.Debugf("Can't evaluate label %v : %v", name, err)
+				return l.Default
+			}
+			if regex == nil {
+				return value.String()
+			}
+			match := regex.FindStringSubmatchIndex(value.String())
+			if match == nil {
+				return l.Default
+			}
+			return string(regex.ExpandString(nil, replacement, value.String(), match))
+		},
+	}, nil
+}
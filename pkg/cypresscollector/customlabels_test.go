@@ -0,0 +1,86 @@
+package cypresscollector
+
+import (
+	"testing"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestCustomLabel_evaluator(t *testing.T) {
+	tests := []struct {
+		name    string
+		label   CustomLabel
+		wantErr bool
+	}{
+		{"Should accept a template over the run", CustomLabel{Name: "branch_kind", Template: "{{ .Run.Commit.Branch }}", Families: []LabelFamily{RunFamily, TestFamily}}, false},
+		{"Should accept a template over the test", CustomLabel{Name: "team", Template: "{{ .Test.Instance.Spec.ShortPath }}", Regex: "^([^/]+)/", Families: []LabelFamily{TestFamily, SpecFamily}}, false},
+		{"Should refuse a test field on runs", CustomLabel{Name: "team", Template: "{{ .Test.Instance.Spec.ShortPath }}", Families: []LabelFamily{RunFamily}}, true},
+		{"Should refuse an unknown field", CustomLabel{Name: "team", Template: "{{ .Run.Comit.Branch }}", Families: []LabelFamily{RunFamily}}, true},
+		{"Should refuse an invalid template", CustomLabel{Name: "team", Template: "{{ .Run.Commit.Branch", Families: []LabelFamily{RunFamily}}, true},
+		{"Should refuse an invalid regex", CustomLabel{Name: "team", Template: "{{ .Run.Commit.Branch }}", Regex: "(", Families: []LabelFamily{RunFamily}}, true},
+		{"Should refuse an invalid name", CustomLabel{Name: "my-team", Template: "x", Families: []LabelFamily{RunFamily}}, true},
+		{"Should refuse an existing label", CustomLabel{Name: "git_branch", Template: "x", Families: []LabelFamily{RunFamily}}, true},
+		{"Should refuse a reserved label", CustomLabel{Name: "state", Template: "x", Families: []LabelFamily{TestFamily}}, true},
+		{"Should refuse an unknown family", CustomLabel{Name: "team", Template: "x", Families: []LabelFamily{"build"}}, true},
+		{"Should refuse a label without family", CustomLabel{Name: "team", Template: "x"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.label.evaluator(); (err != nil) != tt.wantErr {
+				t.Errorf("CustomLabel.evaluator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCustomLabel_evaluate(t *testing.T) {
+	stats := cypressclient.StatsFromCypressDashboard{}
+	stats.Data.Project.Name = "Shop"
+	run := cypressclient.RunResult{}
+	run.Commit.Branch = "Feature/login"
+	test := cypressclient.TestResult{}
+	test.Instance.Spec.ShortPath = "cypress/e2e/checkout/cart.spec.js"
+
+	tests := []struct {
+		name  string
+		label CustomLabel
+		ctx   interface{}
+		want  string
+	}{
+		{
+			name:  "Should render the template",
+			label: CustomLabel{Name: "branch", Template: "{{ .Project.Name }}/{{ lower .Run.Commit.Branch }}", Families: []LabelFamily{RunFamily}},
+			ctx:   run,
+			want:  "Shop/feature/login",
+		},
+		{
+			name:  "Should replace with the first capture group",
+			label: CustomLabel{Name: "team", Template: "{{ .Test.Instance.Spec.ShortPath }}", Regex: "^cypress/e2e/([^/]+)/", Families: []LabelFamily{TestFamily}},
+			ctx:   testContext{run, test},
+			want:  "checkout",
+		},
+		{
+			name:  "Should use the replacement",
+			label: CustomLabel{Name: "team", Template: "{{ .Test.Instance.Spec.ShortPath }}", Regex: "^cypress/(e2e|component)/([^/]+)/", Replacement: "$2-$1", Families: []LabelFamily{TestFamily}},
+			ctx:   testContext{run, test},
+			want:  "checkout-e2e",
+		},
+		{
+			name:  "Should use the default if the regex doesn't match",
+			label: CustomLabel{Name: "team", Template: "{{ .Run.Commit.Branch }}", Regex: "^release/(.*)$", Default: "none", Families: []LabelFamily{RunFamily}},
+			ctx:   run,
+			want:  "none",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := tt.label.evaluator()
+			if err != nil {
+				t.Fatalf("CustomLabel.evaluator() error = %v", err)
+			}
+			if got := e.evaluator(stats, tt.ctx); got != tt.want {
+				t.Errorf("CustomLabel evaluator = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
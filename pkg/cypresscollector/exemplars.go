@@ -0,0 +1,76 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+	"github.com/sirupsen/logrus"
+)
+
+const DefaultDashboardURL = "https://dashboard.cypress.io"
+
+// runURL returns the link to a run on the dashboard.
+func runURL(dashboardURL, projectID string, buildNumber int) string {
+	return fmt.Sprintf("%v/projects/%v/runs/%v", strings.TrimSuffix(dashboardURL, "/"), projectID, buildNumber)
+}
+
+// runExemplarLabels returns the labels linking an exemplar to its run.
+// OpenMetrics limits the labels of an exemplar to 128 characters, so the run ID is dropped first, then the URL.
+func runExemplarLabels(dashboardURL, projectID string, run cypressclient.RunResult) prometheus.Labels {
+	labels := prometheus.Labels{
+		"build_number": strconv.Itoa(run.BuildNumber),
+		"url":          runURL(dashboardURL, projectID, run.BuildNumber),
+		"run_id":       run.ID,
+	}
+	for _, optional := range []string{"run_id", "url"} {
+		if exemplarLabelsLength(labels) <= prometheus.ExemplarMaxRunes {
+			break
+		}
+		delete(labels, optional)
+	}
+	return labels
+}
+
+func exemplarLabelsLength(labels prometheus.Labels) int {
+	length := 0
+	for k, v := range labels {
+		length += utf8.RuneCountInString(k) + utf8.RuneCountInString(v)
+	}
+	return length
+}
+
+func (c *CypressDashboardCollector) runExemplar(metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult, value float64) *prometheus.Exemplar {
+	return &prometheus.Exemplar{
+		Value:     value,
+		Labels:    runExemplarLabels(c.dashboardURL, metrics.Data.Project.ID, run),
+		Timestamp: runCompletedAt(run),
+	}
+}
+
+// observeWithExemplar observes a value in the histogram, with an exemplar linking to the run.
+func (c *CypressDashboardCollector) observeWithExemplar(h prometheus.Observer, value float64, metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult) {
+	if eo, ok := h.(prometheus.ExemplarObserver); ok {
+		eo.ObserveWithExemplar(value, runExemplarLabels(c.dashboardURL, metrics.Data.Project.ID, run))
+		return
+	}
+	h.Observe(value)
+}
+
+// withExemplar attaches the exemplar to the metric, if there's one.
+func withExemplar(metric prometheus.Metric, exemplar *prometheus.Exemplar, transformFunc metricTransformer) prometheus.Metric {
+	if exemplar == nil {
+		return metric
+	}
+	e := *exemplar
+	e.Value = transformFunc(e.Value)
+	res, err := prometheus.NewMetricWithExemplars(metric, e)
+	if err != nil {
+		logrus.Warnf("Skipping exemplar %v : %v", e.Labels, err)
+		return metric
+	}
+	return res
+}
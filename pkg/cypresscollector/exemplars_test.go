@@ -0,0 +1,43 @@
+package cypresscollector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestRunExemplarLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		run  cypressclient.RunResult
+		want prometheus.Labels
+	}{
+		{
+			"Should link to the run",
+			cypressclient.RunResult{ID: "abc", BuildNumber: 42},
+			prometheus.Labels{
+				"build_number": "42",
+				"run_id":       "abc",
+				"url":          "https://dashboard.cypress.io/projects/7s5okt/runs/42",
+			},
+		},
+		{
+			"Should drop the run ID when too long",
+			cypressclient.RunResult{ID: strings.Repeat("a", 60), BuildNumber: 42},
+			prometheus.Labels{
+				"build_number": "42",
+				"url":          "https://dashboard.cypress.io/projects/7s5okt/runs/42",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runExemplarLabels(DefaultDashboardURL+"/", "7s5okt", tt.run); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runExemplarLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
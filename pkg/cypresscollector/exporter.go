@@ -1,12 +1,16 @@
 package cypresscollector
 
 import (
+	"context"
+	"errors"
 	"net/url"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
-	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/converter"
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/set"
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/optional"
@@ -36,6 +40,23 @@ type CypressDashboardCollector struct {
 	CypressRunFlakyTestsSum *prometheus.Desc
 	CypressRunStartTimeSum  *prometheus.Desc
 
+	// Parallelization metrics
+	CypressRunMachines                *prometheus.Desc
+	CypressRunInstancesDuration       *prometheus.Desc
+	CypressRunParallelEfficiency      *prometheus.Desc
+	CypressRunMachineImbalance        *prometheus.Desc
+	CypressRunScheduleError           *prometheus.Desc
+	CypressRunParallelizationDisabled *prometheus.Desc
+
+	// Overhead metrics
+	CypressRunSetupDuration    *prometheus.HistogramVec
+	CypressRunInstanceGap      *prometheus.HistogramVec
+	CypressRunTeardownDuration *prometheus.HistogramVec
+
+	// Duration histograms
+	CypressRunDurationHistogram  *prometheus.HistogramVec
+	CypressTestDurationHistogram *prometheus.HistogramVec
+
 	// Tests metrics
 	CypressTestCount        *prometheus.Desc
 	CypressTestStateSum     *prometheus.Desc
@@ -43,6 +64,17 @@ type CypressDashboardCollector struct {
 	CypressTestDurationSum  *prometheus.Desc
 	CypressTestDurationLast *prometheus.Desc
 
+	// Aggregated metrics, nil if disabled
+	CypressTestDurationEWMA *prometheus.Desc
+	CypressRunDurationMax   *prometheus.Desc
+	CypressTestFlakyRatio   *prometheus.Desc
+	CypressTestFailureRatio *prometheus.Desc
+
+	// Spec files metrics
+	CypressSpecDuration *prometheus.Desc
+	CypressSpecStatus   *prometheus.Desc
+	CypressSpecTests    *prometheus.Desc
+
 	// Other metrics for DD availability
 	CypressDashboardExporterAvailable *prometheus.Desc
 
@@ -55,176 +87,592 @@ type CypressDashboardCollector struct {
 	TotalAnalysedTests  int
 
 	AlreadyProcessedBuilds set.IntSet
-	runSummary             metricsmap.MetricMapSumValues
-	testSummary            metricsmap.MetricMapSumValues
-	runLatest              metricsmap.MetricMapKeepFirst
-	testLatest             metricsmap.MetricMapKeepFirst
+	runSummary             metricsmap.MetricMapSumValues[float64]
+	testSummary            metricsmap.MetricMapSumValues[float64]
+	runLatest              metricsmap.MetricMapKeepFirst[float64]
+	testLatest             metricsmap.MetricMapKeepFirst[float64]
+	specLatest             metricsmap.MetricMapKeepFirst[float64]
+	testDurationEWMA       metricsmap.MetricMapAggregate
+	runDurationMax         metricsmap.MetricMapAggregate
+	testFlakyRatio         metricsmap.MetricMapAggregate
+	testFailureRatio       metricsmap.MetricMapAggregate
+	runSetupDuration       metricsmap.MetricMapHistogram
+	runInstanceGap         metricsmap.MetricMapHistogram
+	runTeardownDuration    metricsmap.MetricMapHistogram
+	runDurationHistogram   metricsmap.MetricMapHistogram
+	testDurationHistogram  metricsmap.MetricMapHistogram
 	firstRequest           bool
 	project                string
+
+	// Names under which the metrics are exported
+	metricNames *metricNames
+	// Timestamps of the gauges, nil if they're sent with the scrape time
+	timestamps *timestampGuard
+	// Used to link exemplars to the runs on the dashboard
+	dashboardURL string
+	// Options the collector was created with
+	opts CollectorOptions
+	// Metrics about the exporter itself
+	self *exporterMetrics
+	// Labels of the project level metrics, from the last successful request
+	lastProjectLabels []string
+	// Total number of runs of the project, from the last successful request
+	runsCount float64
+
+	// State of the synchronisation with the dashboard
+	lastSuccess time.Time
+	lastError   error
+	// Protects the state between the poller and the scrapes
+	mu sync.Mutex
+	// Holds a token while a sync is in progress, so that the concurrent probes of a project sync one at a time
+	syncing chan struct{}
+
+	// Labels of each family of metrics, depending on the options
+	projectFamilyLabels []labelsEvaluatorImpl
+	runInstanceLabels   []labelsEvaluatorImpl
+	overheadLabels      []labelsEvaluatorImpl
+	testLabels          []labelsEvaluatorImpl
+	specLabels          []labelsEvaluatorImpl
+	// Labels of the test duration histogram. The test name is left out, since each of them would create a serie per bucket.
+	testHistogramLabels []labelsEvaluatorImpl
+	// Limits of the label combinations of the families
+	budgets map[LabelFamily]*metricsmap.Budget
+	// Time of the syncs, the updates and the expirations
+	clock clock.Clock
+}
+
+// CollectorOptions groups the optional behaviours of the collector.
+type CollectorOptions struct {
+	Author      AuthorLabelOptions
+	Histograms  HistogramOptions
+	MetricNames MetricNamesMode
+	Timestamps  TimestampOptions
+	// Base URL of the dashboard, used in the exemplars. Defaults to DefaultDashboardURL.
+	DashboardURL string
+	// Number of runs fetched by the first sync. Defaults to DefaultBacklog.
+	Backlog int
+	// Labels of each family of metrics. Defaults to DefaultFamilyLabels.
+	Labels LabelOptions
+	// Retention of the families, overriding the keepUntil of the collector
+	Retention RetentionOptions
+	// Gauges aggregated over the processed runs
+	Aggregations AggregationOptions
+	// Defaults to the system clock, replaced in tests
+	Clock clock.Clock
 }
 
-func NewCypressDashboardCollector(endpoint url.URL, project, email, password string, keepUntil int64) (*CypressDashboardCollector, error) {
+// Number of runs processed at startup, before only fetching the latest ones
+const DefaultBacklog = 40
+
+func NewCypressDashboardCollector(endpoint url.URL, project, email, password string, keepUntil int64, opts CollectorOptions) (*CypressDashboardCollector, error) {
+
+	if err := opts.Author.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Histograms.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := opts.Labels.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Retention.Validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.Aggregations.Validate(); err != nil {
+		return nil, err
+	}
+	// The families were validated above
+	projectFamilyLabels, _ := opts.Labels.evaluators(ProjectFamily)
+	runInstanceLabels, _ := opts.Labels.evaluators(RunFamily)
+	overheadLabels, _ := opts.Labels.evaluators(OverheadFamily)
+	testLabels, _ := opts.Labels.evaluators(TestFamily)
+	specLabels, _ := opts.Labels.evaluators(SpecFamily)
+	if opts.Author.Enabled() {
+		runInstanceLabels = append(runInstanceLabels, authorLabel(opts.Author))
+	}
+
+	testHistogramLabels := withoutLabels(testLabels, "name")
+	budgets := map[LabelFamily]*metricsmap.Budget{
+		RunFamily:  newBudget(opts.Labels.Limits[RunFamily], runInstanceLabels),
+		TestFamily: newBudget(opts.Labels.Limits[TestFamily], testLabels),
+		SpecFamily: newBudget(opts.Labels.Limits[SpecFamily], specLabels),
+	}
+
+	names := newMetricNames(opts.MetricNames)
+	if opts.DashboardURL == "" {
+		opts.DashboardURL = DefaultDashboardURL
+	}
+	if opts.Backlog <= 0 {
+		opts.Backlog = DefaultBacklog
+	}
+	var timestamps *timestampGuard
+	if opts.Timestamps.Enabled {
+		timestamps = newTimestampGuard(opts.Timestamps.MaxAge)
+	}
 
+	self := newExporterMetrics(project)
 	client := cypressclient.NewCypressDashboardMetricsClient(endpoint, email, password)
-	return &CypressDashboardCollector{
-		CypressRunsCount: prometheus.NewDesc("cypress_runs_total", "Total number of runs", labelsInOrder(RunsOrderedLabels), prometheus.Labels{}),
-
-		CypressRunPassed:     prometheus.NewDesc("cypress_run_passed_total_last", "Total number of passed test per run processed ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunFailed:     prometheus.NewDesc("cypress_run_failed_total_last", "Total number of failed test per run processed ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunPending:    prometheus.NewDesc("cypress_run_pending_total_last", "Total number of pending test per run processed ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunSkipped:    prometheus.NewDesc("cypress_run_skipped_total_last", "Total number of skipped test per run processed ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunMutedTests: prometheus.NewDesc("cypress_run_muted_tests_total_last", "Total number of muted tests processed ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunFlakyTests: prometheus.NewDesc("cypress_run_flaky_tests_total_last", "Total number of flaky tests processed ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunDuration:   prometheus.NewDesc("cypress_run_duration_ms_last", " Duration of a processed run ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunStartTime:  prometheus.NewDesc("cypress_run_start_time_ms_last", "Start time of a processed run ( latest value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-
-		CypressRunPassedSum:     prometheus.NewDesc("cypress_run_passed_sum", "Total number of passed test per run processed ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunFailedSum:     prometheus.NewDesc("cypress_run_failed_sum", "Total number of failed test per run processed ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunPendingSum:    prometheus.NewDesc("cypress_run_pending_sum", "Total number of pending test per run processed ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunSkippedSum:    prometheus.NewDesc("cypress_run_skipped_sum", "Total number of skipped test per run processed ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunMutedTestsSum: prometheus.NewDesc("cypress_run_muted_tests_sum", "Total number of muted runs processed ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunFlakyTestsSum: prometheus.NewDesc("cypress_run_flaky_tests_sum", "Total number of flaky tests processed ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunDurationSum:   prometheus.NewDesc("cypress_run_duration_ms_sum", "Duration of a processed run ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-		CypressRunStartTimeSum:  prometheus.NewDesc("cypress_run_start_time_ms_sum", "Start time of a processed run ( summed value )", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-
-		CypressRunCount: prometheus.NewDesc("cypress_run_processed_sum", "Count of processed runs", labelsInOrder(RunInstanceOrderedLabels), prometheus.Labels{}),
-
-		CypressTestStateLast:    prometheus.NewDesc("cypress_test_state_last", "Last state of a test ( filter with label `state` and check for value 1.0 )", labelsInOrder(TestResultInstanceOrderedLabels("")), prometheus.Labels{}),
-		CypressTestDurationLast: prometheus.NewDesc("cypress_test_duration_ms_total_last", "Last duration of a test", labelsInOrder(TestInstanceOrderedLabels), prometheus.Labels{}),
-		CypressTestStateSum:     prometheus.NewDesc("cypress_test_state_sum", "Summed state of a test ( filter with label `state` and check for value 1.0 )", labelsInOrder(TestResultInstanceOrderedLabels("")), prometheus.Labels{}),
-		CypressTestDurationSum:  prometheus.NewDesc("cypress_test_duration_ms_total_sum", "Summed duration of a test", labelsInOrder(TestInstanceOrderedLabels), prometheus.Labels{}),
-		CypressTestCount:        prometheus.NewDesc("cypress_test_processed_count", "Total number of processed tests", labelsInOrder(TestInstanceOrderedLabels), prometheus.Labels{}),
-
-		CypressDashboardExporterAvailable: prometheus.NewDesc("cypress_dashboard_exporter_available", "Availability of CypressDashbboardExporter", labelsInOrder(RunsOrderedLabels), prometheus.Labels{}),
-
-		cli:          &client,
+	client.SetClock(opts.Clock)
+	self.instrument(client)
+	c := &CypressDashboardCollector{
+		CypressRunsCount: names.desc("cypress_project_runs", "cypress_runs_total", "Total number of runs of the project", labelsInOrder(projectFamilyLabels), noopTransformer),
+
+		CypressRunPassed:     names.desc("cypress_run_passed_tests_last", "cypress_run_passed_total_last", "Total number of passed test per run processed ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunFailed:     names.desc("cypress_run_failed_tests_last", "cypress_run_failed_total_last", "Total number of failed test per run processed ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunPending:    names.desc("cypress_run_pending_tests_last", "cypress_run_pending_total_last", "Total number of pending test per run processed ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunSkipped:    names.desc("cypress_run_skipped_tests_last", "cypress_run_skipped_total_last", "Total number of skipped test per run processed ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunMutedTests: names.desc("cypress_run_muted_tests_last", "cypress_run_muted_tests_total_last", "Total number of muted tests processed ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunFlakyTests: names.desc("cypress_run_flaky_tests_last", "cypress_run_flaky_tests_total_last", "Total number of flaky tests processed ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunDuration:   names.desc("cypress_run_duration_seconds_last", "cypress_run_duration_ms_last", "Duration of a processed run ( latest value )", labelsInOrder(runInstanceLabels), msToSec),
+		CypressRunStartTime:  names.desc("cypress_run_start_timestamp_seconds_last", "cypress_run_start_time_ms_last", "Start time of a processed run, as a unix timestamp ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+
+		CypressRunPassedSum:     names.desc("cypress_run_passed_tests_total", "cypress_run_passed_sum", "Total number of passed test per run processed ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunFailedSum:     names.desc("cypress_run_failed_tests_total", "cypress_run_failed_sum", "Total number of failed test per run processed ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunPendingSum:    names.desc("cypress_run_pending_tests_total", "cypress_run_pending_sum", "Total number of pending test per run processed ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunSkippedSum:    names.desc("cypress_run_skipped_tests_total", "cypress_run_skipped_sum", "Total number of skipped test per run processed ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunMutedTestsSum: names.desc("cypress_run_muted_tests_total", "cypress_run_muted_tests_sum", "Total number of muted runs processed ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunFlakyTestsSum: names.desc("cypress_run_flaky_tests_total", "cypress_run_flaky_tests_sum", "Total number of flaky tests processed ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunDurationSum:   names.desc("", "cypress_run_duration_ms_sum", "Duration of a processed run ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunStartTimeSum:  names.desc("", "cypress_run_start_time_ms_sum", "Start time of a processed run ( summed value )", labelsInOrder(runInstanceLabels), noopTransformer),
+
+		CypressRunMachines:                names.desc("cypress_run_machines_last", "cypress_run_machines_last", "Number of machines used by a processed run ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunInstancesDuration:       names.desc("cypress_run_instances_duration_seconds_last", "cypress_run_instances_duration_ms_last", "Duration of all the spec files of a processed run, summed across machines ( latest value )", labelsInOrder(runInstanceLabels), msToSec),
+		CypressRunParallelEfficiency:      names.desc("cypress_run_parallel_efficiency_last", "cypress_run_parallel_efficiency_last", "Ratio between the summed spec files duration and the wall clock duration multiplied by the number of machines ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+		CypressRunMachineImbalance:        names.desc("cypress_run_machine_imbalance_seconds_last", "cypress_run_machine_imbalance_ms_last", "Difference of busy time between the most and the least loaded machines of a processed run ( latest value )", labelsInOrder(runInstanceLabels), msToSec),
+		CypressRunScheduleError:           names.desc("cypress_run_schedule_error_seconds_last", "cypress_run_schedule_error_ms_last", "Difference between the real and the scheduled completion time of a processed run, positive when late ( latest value )", labelsInOrder(runInstanceLabels), msToSec),
+		CypressRunParallelizationDisabled: names.desc("cypress_run_parallelization_disabled_last", "cypress_run_parallelization_disabled_last", "1 if parallelization was disabled for a processed run ( latest value )", labelsInOrder(runInstanceLabels), noopTransformer),
+
+		CypressRunSetupDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cypress_run_setup_duration_seconds",
+			Help:    "Time between the creation of a processed run and the start of its first spec file",
+			Buckets: overheadBuckets,
+		}, labelsInOrder(overheadLabels)),
+		CypressRunInstanceGap: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cypress_run_instance_gap_seconds",
+			Help:    "Idle time of a machine between two consecutive spec files of a processed run",
+			Buckets: overheadBuckets,
+		}, labelsInOrder(overheadLabels)),
+		CypressRunTeardownDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cypress_run_teardown_duration_seconds",
+			Help:    "Time between the completion of the last spec file of a processed run and the end of the run",
+			Buckets: overheadBuckets,
+		}, labelsInOrder(overheadLabels)),
+
+		CypressRunDurationHistogram: prometheus.NewHistogramVec(opts.Histograms.histogramOpts(
+			"cypress_run_duration_seconds", "Duration of the processed runs",
+			opts.Histograms.RunDurationBuckets, DefaultRunDurationBuckets,
+		), labelsInOrder(runInstanceLabels)),
+		CypressTestDurationHistogram: prometheus.NewHistogramVec(opts.Histograms.histogramOpts(
+			"cypress_test_duration_seconds", "Duration of the processed tests",
+			opts.Histograms.TestDurationBuckets, DefaultTestDurationBuckets,
+		), labelsInOrder(testHistogramLabels)),
+
+		CypressRunCount: names.desc("cypress_run_processed_total", "cypress_run_processed_sum", "Count of processed runs", labelsInOrder(runInstanceLabels), noopTransformer),
+
+		CypressTestStateLast:    names.desc("cypress_test_state_last", "cypress_test_state_last", "Last state of a test ( filter with label `state` and check for value 1.0 )", labelsInOrder(withState(testLabels, "")), noopTransformer),
+		CypressTestDurationLast: names.desc("cypress_test_duration_seconds_last", "cypress_test_duration_ms_total_last", "Last duration of a test", labelsInOrder(testLabels), msToSec),
+		CypressTestStateSum:     names.desc("cypress_test_state_total", "cypress_test_state_sum", "Summed state of a test ( filter with label `state` and check for value 1.0 )", labelsInOrder(withState(testLabels, "")), noopTransformer),
+		CypressTestDurationSum:  names.desc("cypress_test_runtime_seconds_total", "cypress_test_duration_ms_total_sum", "Summed duration of a test", labelsInOrder(testLabels), msToSec),
+		CypressTestCount:        names.desc("cypress_test_processed_total", "cypress_test_processed_count", "Total number of processed tests", labelsInOrder(testLabels), noopTransformer),
+
+		CypressSpecDuration: names.desc("cypress_spec_duration_seconds_last", "cypress_spec_duration", "Duration of a spec file on the machine that ran it ( latest value )", labelsInOrder(specLabels), msToSec),
+		CypressSpecStatus:   names.desc("cypress_spec_status_last", "cypress_spec_status", "Last status of a spec file ( filter with label `state` and check for value 1.0 )", labelsInOrder(withState(specLabels, "")), noopTransformer),
+		CypressSpecTests:    names.desc("cypress_spec_tests_last", "cypress_spec_tests", "Number of tests of a spec file ( latest value )", labelsInOrder(specLabels), noopTransformer),
+
+		CypressDashboardExporterAvailable: names.desc("cypress_dashboard_exporter_available", "cypress_dashboard_exporter_available", "Availability of CypressDashbboardExporter", labelsInOrder(projectFamilyLabels), noopTransformer),
+
+		cli:          client,
 		project:      project,
 		LastDateTest: time.Date(2006, 1, 1, 1, 1, 1, 1, time.UTC),
 		LastBuild:    0,
 
 		AlreadyProcessedBuilds: set.NewIntSet(),
-		runSummary: metricsmap.MetricMapSumValues{
-			KeepUntil: time.Duration(time.Duration(keepUntil)),
+		runSummary: metricsmap.MetricMapSumValues[float64]{
+			Budget: budgets[RunFamily],
+			Clock:  clock.OrReal(opts.Clock),
 		},
-		testSummary: metricsmap.MetricMapSumValues{
-			KeepUntil: time.Duration(time.Duration(keepUntil)),
+		testSummary: metricsmap.MetricMapSumValues[float64]{
+			Budget: budgets[TestFamily],
+			Clock:  clock.OrReal(opts.Clock),
 		},
 
-		runLatest: metricsmap.MetricMapKeepFirst{
-			KeepUntil: time.Duration(time.Duration(keepUntil)),
+		runLatest: metricsmap.MetricMapKeepFirst[float64]{
+			Budget: budgets[RunFamily],
+			Clock:  clock.OrReal(opts.Clock),
 		},
-		testLatest: metricsmap.MetricMapKeepFirst{
-			KeepUntil: time.Duration(time.Duration(keepUntil)),
+		testLatest: metricsmap.MetricMapKeepFirst[float64]{
+			Budget: budgets[TestFamily],
+			Clock:  clock.OrReal(opts.Clock),
+		},
+		specLatest: metricsmap.MetricMapKeepFirst[float64]{
+			Budget: budgets[SpecFamily],
+			Clock:  clock.OrReal(opts.Clock),
+		},
+		testDurationEWMA: metricsmap.MetricMapAggregate{
+			Budget: budgets[TestFamily],
+			Clock:  clock.OrReal(opts.Clock),
+		},
+		runDurationMax: metricsmap.MetricMapAggregate{
+			Budget: budgets[RunFamily],
+			Clock:  clock.OrReal(opts.Clock),
+		},
+		testFlakyRatio: metricsmap.MetricMapAggregate{
+			Budget: budgets[TestFamily],
+			Clock:  clock.OrReal(opts.Clock),
+		},
+		testFailureRatio: metricsmap.MetricMapAggregate{
+			Budget: budgets[TestFamily],
+			Clock:  clock.OrReal(opts.Clock),
 		},
 
-		firstRequest: true,
-	}, nil
+		firstRequest:        true,
+		syncing:             make(chan struct{}, 1),
+		metricNames:         names,
+		timestamps:          timestamps,
+		dashboardURL:        opts.DashboardURL,
+		opts:                opts,
+		self:                self,
+		projectFamilyLabels: projectFamilyLabels,
+		runInstanceLabels:   runInstanceLabels,
+		overheadLabels:      overheadLabels,
+		testLabels:          testLabels,
+		specLabels:          specLabels,
+		testHistogramLabels: testHistogramLabels,
+		budgets:             budgets,
+		clock:               clock.OrReal(opts.Clock),
+	}
+	c.runSetupDuration = c.newHistogramMap(c.CypressRunSetupDuration, overheadLabels, RunFamily)
+	c.runInstanceGap = c.newHistogramMap(c.CypressRunInstanceGap, overheadLabels, RunFamily)
+	c.runTeardownDuration = c.newHistogramMap(c.CypressRunTeardownDuration, overheadLabels, RunFamily)
+	c.runDurationHistogram = c.newHistogramMap(c.CypressRunDurationHistogram, runInstanceLabels, RunFamily)
+	c.testDurationHistogram = c.newHistogramMap(c.CypressTestDurationHistogram, testHistogramLabels, TestFamily)
+	c.newAggregations(opts.Aggregations, opts.Retention.keepUntil(RunFamily, time.Duration(keepUntil)))
+	c.applyRetention(time.Duration(keepUntil), opts.Retention)
+	c.runSummary.OnEvict = c.countEvictions(RunFamily)
+	c.runLatest.OnEvict = c.countEvictions(RunFamily)
+	c.testSummary.OnEvict = c.countEvictions(TestFamily)
+	c.testLatest.OnEvict = c.countEvictions(TestFamily)
+	c.specLatest.OnEvict = c.countEvictions(SpecFamily)
+	c.testDurationEWMA.OnEvict = c.countEvictions(TestFamily)
+	c.runDurationMax.OnEvict = c.countEvictions(RunFamily)
+	c.testFlakyRatio.OnEvict = c.countEvictions(TestFamily)
+	c.testFailureRatio.OnEvict = c.countEvictions(TestFamily)
+	if dropsRunEntryLabels(TestFamily, testLabels) {
+		c.testLatest.Ties = map[*prometheus.Desc]metricsmap.Tie[float64]{
+			c.CypressTestDurationLast: metricsmap.SumTie[float64],
+			c.CypressTestStateLast:    metricsmap.MaxTie[float64],
+		}
+	}
+	if dropsRunEntryLabels(SpecFamily, specLabels) {
+		c.specLatest.Ties = map[*prometheus.Desc]metricsmap.Tie[float64]{
+			c.CypressSpecDuration: metricsmap.SumTie[float64],
+			c.CypressSpecTests:    metricsmap.SumTie[float64],
+			c.CypressSpecStatus:   metricsmap.MaxTie[float64],
+		}
+	}
+	return c, nil
 
 }
 
 func (c *CypressDashboardCollector) Describe(ch chan<- *prometheus.Desc) {
-	ch <- c.CypressRunsCount
-	ch <- c.CypressRunPassed
-	ch <- c.CypressRunFailed
-	ch <- c.CypressRunPending
-	ch <- c.CypressRunSkipped
-	ch <- c.CypressRunMutedTests
-	ch <- c.CypressRunDuration
-	ch <- c.CypressRunFlakyTests
-	ch <- c.CypressRunStartTime
-	ch <- c.CypressRunPassedSum
-	ch <- c.CypressRunFailedSum
-	ch <- c.CypressRunPendingSum
-	ch <- c.CypressRunSkippedSum
-	ch <- c.CypressRunMutedTestsSum
-	ch <- c.CypressRunDurationSum
-	ch <- c.CypressRunFlakyTestsSum
-	ch <- c.CypressRunStartTimeSum
-	ch <- c.CypressRunCount
-	ch <- c.CypressTestCount
-	ch <- c.CypressTestStateSum
-	ch <- c.CypressTestStateLast
-	ch <- c.CypressTestDurationSum
-	ch <- c.CypressTestDurationLast
-	ch <- c.CypressDashboardExporterAvailable
+	c.metricNames.describe(ch)
+	c.CypressRunSetupDuration.Describe(ch)
+	c.CypressRunInstanceGap.Describe(ch)
+	c.CypressRunTeardownDuration.Describe(ch)
+	c.CypressRunDurationHistogram.Describe(ch)
+	c.CypressTestDurationHistogram.Describe(ch)
+	c.self.Describe(ch)
 }
 
-// maybeMetric Send metric, if exist, to chanel. If value of metric is nil, or uncastable to float64, then print a warning or an error.
-// Transform func will transform
-func maybeMetric(ch chan<- prometheus.Metric, p *prometheus.Desc, valueType prometheus.ValueType, value interface{}, transformFunc metricTransformer, labels []string) {
-	prometheusValue, err := converter.ConvertValueForPrometheus(value)
-
-	if err != nil {
-		logrus.Errorf("Skipping metric %v : %v", p.String(), err)
-		return
-	}
-
-	ch <- prometheus.MustNewConstMetric(
+// maybeMetric Send metric to chanel, with its value transformed by transformFunc ( such as converting ms to seconds ).
+// If timestamp isn't zero, it's sent along with the metric, otherwise prometheus uses the scrape time.
+func maybeMetric(ch chan<- prometheus.Metric, p *prometheus.Desc, valueType prometheus.ValueType, value float64, transformFunc metricTransformer, labels []string, timestamp time.Time, exemplar *prometheus.Exemplar) {
+	metric := prometheus.MustNewConstMetric(
 		p,
 		valueType,
-		transformFunc(prometheusValue),
+		transformFunc(value),
 		labels...,
 	)
+	metric = withExemplar(metric, exemplar, transformFunc)
+	if !timestamp.IsZero() {
+		metric = prometheus.NewMetricWithTimestamp(timestamp, metric)
+	}
+	ch <- metric
 }
 
-func (c *CypressDashboardCollector) Collect(ch chan<- prometheus.Metric) {
+// timestampOf returns the timestamp to send with a gauge, or a zero time if it should be sent with the scrape time.
+func (c *CypressDashboardCollector) timestampOf(key metricsmap.Key, value metricsmap.Value[float64]) time.Time {
+	if c.timestamps == nil || value.Timestamp.IsZero() {
+		return time.Time{}
+	}
+	return c.timestamps.timestamp(key, value.Value, value.Timestamp, c.clock.Now())
+}
+
+// Sync fetches the latest runs of the project from the dashboard, and processes them. Concurrent syncs wait for the
+// one in progress, until their context is done.
+// A sync canceled by the context leaves the state as it was, a sync past the deadline of the context is an error.
+// The backlog is only requested by the first sync : if it doesn't complete in time, the next ones request the latest
+// runs, so that a backlog too slow to fetch doesn't make every sync fail.
+func (c *CypressDashboardCollector) Sync(ctx context.Context) error {
+	select {
+	case c.syncing <- struct{}{}:
+		defer func() { <-c.syncing }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
 	opts := cypressclient.EmptyMetricOptions()
-	if c.firstRequest {
-		backlog := 40
+	c.mu.Lock()
+	backlog := c.firstRequest
+	c.mu.Unlock()
+	if backlog {
 		logrus.Info("Processing the backlog of multiple requests")
-		opts.Size = optional.NewOptionalInt(&backlog)
-		c.firstRequest = false
+		opts.Size = optional.Some(c.opts.Backlog)
 	}
 	// Set the project in the request
 	opts.Project = c.project
-	metrics, err := c.cli.GetMetrics(opts)
+	metrics, err := c.cli.GetMetrics(ctx, opts)
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if backlog && ctx.Err() != nil {
+		logrus.Warnf("The backlog of project %v couldn't be fetched in time, only the latest runs will be processed", c.project)
+		c.firstRequest = false
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	}
+	c.lastError = err
 	if err != nil {
-		logrus.Errorln("Error while scraping CypressDashboard metrics:", err)
-		ch <- prometheus.NewInvalidMetric(c.CypressDashboardExporterAvailable, err)
-		return
+		c.self.countError(err)
+		return err
+	}
+	c.firstRequest = false
+	c.lastSuccess = c.clock.Now()
+	c.self.lastSuccess.Set(float64(c.lastSuccess.Unix()))
+	c.lastProjectLabels = evaluateLabels(c.projectFamilyLabels, *metrics, nil)
+	c.runsCount = float64(metrics.Data.Project.Runs.TotalCount)
+	c.processRuns(metrics)
+	c.reportBudgets()
+	return nil
+}
+
+// Reload applies a new configuration, keeping the runs already processed.
+// Options changing the exported series can't be applied without losing them, they're ignored until the next restart.
+func (c *CypressDashboardCollector) Reload(email, password string, keepUntil int64, opts CollectorOptions) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cli.SetCredentials(email, password)
+	c.applyRetention(time.Duration(keepUntil), opts.Retention)
+	c.opts.Retention = opts.Retention
+
+	if opts.DashboardURL == "" {
+		opts.DashboardURL = DefaultDashboardURL
+	}
+	c.dashboardURL = opts.DashboardURL
+	c.opts.DashboardURL = opts.DashboardURL
+
+	for _, option := range restartNeeded(c.opts, opts) {
+		logrus.Warnf("Option %v of project %v changed, it will only be applied after a restart", option, c.project)
+	}
+}
+
+// restartNeeded returns the options that changed, and can't be applied without restarting the collector.
+func restartNeeded(current, next CollectorOptions) []string {
+	res := []string{}
+	if !reflect.DeepEqual(current.Author, next.Author) {
+		res = append(res, "author")
+	}
+	if !reflect.DeepEqual(current.Histograms, next.Histograms) {
+		res = append(res, "histograms")
 	}
+	if current.MetricNames != next.MetricNames {
+		res = append(res, "metricNames")
+	}
+	if current.Timestamps != next.Timestamps {
+		res = append(res, "timestamps")
+	}
+	if !reflect.DeepEqual(current.Labels, next.Labels) {
+		res = append(res, "labels")
+	}
+	if current.Aggregations != next.Aggregations {
+		res = append(res, "aggregations")
+	}
+	return res
+}
 
-	// Project level metrics
-	maybeMetric(ch, c.CypressRunsCount, prometheus.GaugeValue, metrics.Data.Project.Runs.TotalCount, noopTransformer, evaluateLabels(RunsOrderedLabels, *metrics, nil))
+// Run syncs the dashboard every interval, until the context is done. A sync in progress is canceled, and a sync
+// lasting longer than the interval fails, so that a hung request doesn't block the polling.
+// The expired entries are removed in the background meanwhile.
+func (c *CypressDashboardCollector) Run(ctx context.Context, interval time.Duration) {
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		c.runSweeper(ctx, sweepInterval)
+	}()
+	defer func() { <-sweeperDone }()
+
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		syncCtx, cancel := context.WithTimeout(ctx, interval)
+		err := c.Sync(syncCtx)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			// Keep sending what has already been processed, it's still valid
+			logrus.Errorln("Error while scraping CypressDashboard metrics:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (c *CypressDashboardCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.metricNames.resetSeries()
+	if c.lastSuccess.IsZero() || c.lastError != nil {
+		c.metricNames.emit(ch, c.CypressDashboardExporterAvailable, prometheus.GaugeValue, 0.0, c.projectLabels(), time.Time{}, nil)
+	} else {
+		c.metricNames.emit(ch, c.CypressDashboardExporterAvailable, prometheus.GaugeValue, 1.0, c.projectLabels(), time.Time{}, nil)
+	}
+	if !c.lastSuccess.IsZero() {
+		// Project level metrics
+		c.metricNames.emit(ch, c.CypressRunsCount, prometheus.GaugeValue, c.runsCount, c.projectLabels(), time.Time{}, nil)
+	}
+
+	for key, value := range c.runSummary.Map() {
+		logrus.Debugln("Processing summary ( counters )", key.Prom.String())
+		c.metricNames.emit(ch, key.Prom, prometheus.CounterValue, value.Value, value.Labels, time.Time{}, value.Exemplar)
+	}
+	for key, value := range c.runLatest.Map() {
+		logrus.Debugln("Processing latests ( gauge )", key.Prom.String())
+		c.metricNames.emit(ch, key.Prom, prometheus.GaugeValue, value.Value, value.Labels, c.timestampOf(key, value), nil)
+	}
 
+	for key, value := range c.testSummary.Map() {
+		logrus.Debugln("Processing tests summary ( counters )", key.Prom.String())
+		c.metricNames.emit(ch, key.Prom, prometheus.CounterValue, value.Value, value.Labels, time.Time{}, value.Exemplar)
+	}
+	for key, value := range c.testLatest.Map() {
+		logrus.Debugln("Processing test latests ( gauge )", key.Prom.String())
+		c.metricNames.emit(ch, key.Prom, prometheus.GaugeValue, value.Value, value.Labels, c.timestampOf(key, value), nil)
+	}
+	for key, value := range c.specLatest.Map() {
+		logrus.Debugln("Processing spec latests ( gauge )", key.Prom.String())
+		c.metricNames.emit(ch, key.Prom, prometheus.GaugeValue, value.Value, value.Labels, c.timestampOf(key, value), nil)
+	}
+	for key, value := range c.runDurationMax.Map() {
+		logrus.Debugln("Processing run aggregates ( gauge )", key.Prom.String())
+		c.metricNames.emit(ch, key.Prom, prometheus.GaugeValue, value.Value, value.Labels, c.timestampOf(key, value), nil)
+	}
+	for _, m := range []*metricsmap.MetricMapAggregate{&c.testDurationEWMA, &c.testFlakyRatio, &c.testFailureRatio} {
+		for key, value := range m.Map() {
+			logrus.Debugln("Processing test aggregates ( gauge )", key.Prom.String())
+			c.metricNames.emit(ch, key.Prom, prometheus.GaugeValue, value.Value, value.Labels, c.timestampOf(key, value), nil)
+		}
+	}
+	c.CypressRunSetupDuration.Collect(ch)
+	c.CypressRunInstanceGap.Collect(ch)
+	c.CypressRunTeardownDuration.Collect(ch)
+	c.CypressRunDurationHistogram.Collect(ch)
+	c.CypressTestDurationHistogram.Collect(ch)
+	if c.timestamps != nil {
+		c.timestamps.sweep()
+	}
+
+	c.updateSeries()
+	c.self.Collect(ch)
+}
+
+// updateSeries sets the series gauge from the metrics emitted during the scrape and the label sets of the histograms.
+func (c *CypressDashboardCollector) updateSeries() {
+	c.self.series.Reset()
+	for family, count := range c.metricNames.series {
+		c.self.series.WithLabelValues(family).Set(float64(count))
+	}
+	histograms := map[string]*metricsmap.MetricMapHistogram{
+		"cypress_run_setup_duration_seconds":    &c.runSetupDuration,
+		"cypress_run_instance_gap_seconds":      &c.runInstanceGap,
+		"cypress_run_teardown_duration_seconds": &c.runTeardownDuration,
+		"cypress_run_duration_seconds":          &c.runDurationHistogram,
+		"cypress_test_duration_seconds":         &c.testDurationHistogram,
+	}
+	for family, m := range histograms {
+		c.self.series.WithLabelValues(family).Set(float64(m.Len()))
+	}
+}
+
+// projectLabels returns the labels of the project level metrics, from the last successful request.
+func (c *CypressDashboardCollector) projectLabels() []string {
+	if c.lastProjectLabels != nil {
+		return c.lastProjectLabels
+	}
+	stats := cypressclient.StatsFromCypressDashboard{}
+	stats.Data.Project.ID = c.project
+	return evaluateLabels(c.projectFamilyLabels, stats, nil)
+}
+
+// processRuns adds the runs of the response to the metric maps.
+func (c *CypressDashboardCollector) processRuns(metrics *cypressclient.StatsFromCypressDashboard) {
 	for _, runInstance := range metrics.Data.Project.Runs.Nodes.Reverse() {
 
 		// First result => Latest build
 		logrus.Infof("Processing build %v started at %v in state %v", runInstance.BuildNumber, runInstance.StartTime, runInstance.Status)
 		if c.AlreadyProcessedBuilds.Has(runInstance.BuildNumber) {
 			logrus.Infoln("Already processed build id", runInstance.BuildNumber)
+			c.self.runsSkipped.WithLabelValues(skippedAlreadyProcessed).Inc()
 		} else if runInstance.Status == "PASSED" || runInstance.Status == "FAILED" {
 			logrus.Infoln("Processing build id", runInstance.BuildNumber)
-
-			c.runLatest.Add(c.CypressRunPassed, runInstance.TotalPassed, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunPending, runInstance.TotalPending, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunFailed, runInstance.TotalFailed, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunMutedTests, runInstance.TotalMutedTests, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunSkipped, runInstance.TotalSkipped, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunFlakyTests, runInstance.TotalFlakyTests, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunDuration, runInstance.TotalDuration, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runLatest.Add(c.CypressRunStartTime, runInstance.StartTime, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
+			completedAt := runCompletedAt(runInstance)
+
+			c.runLatest.AddAt(c.CypressRunPassed, float64(runInstance.TotalPassed), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunPending, float64(runInstance.TotalPending), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunFailed, float64(runInstance.TotalFailed), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunMutedTests, float64(runInstance.TotalMutedTests), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunSkipped, float64(runInstance.TotalSkipped), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunFlakyTests, float64(runInstance.TotalFlakyTests), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunDuration, float64(runInstance.TotalDuration), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runLatest.AddAt(c.CypressRunStartTime, float64(runInstance.StartTime.Unix()), completedAt, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
 			// c.runLatest.Lock() // As soon as we processed the last build, we lock the map ( since latest build appears first in results )
 
-			c.runSummary.Add(c.CypressRunPassedSum, runInstance.TotalPassed, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunPendingSum, runInstance.TotalPending, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunFailedSum, runInstance.TotalFailed, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunMutedTestsSum, runInstance.TotalMutedTests, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunSkippedSum, runInstance.TotalSkipped, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunFlakyTestsSum, runInstance.TotalFlakyTests, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunDurationSum, runInstance.TotalDuration, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
-			c.runSummary.Add(c.CypressRunStartTimeSum, runInstance.StartTime, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunPassedSum, float64(runInstance.TotalPassed), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunPendingSum, float64(runInstance.TotalPending), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			var failedExemplar *prometheus.Exemplar
+			if runInstance.TotalFailed > 0 {
+				failedExemplar = c.runExemplar(*metrics, runInstance, float64(runInstance.TotalFailed))
+			}
+			c.runSummary.AddWithExemplar(c.CypressRunFailedSum, float64(runInstance.TotalFailed), failedExemplar, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunMutedTestsSum, float64(runInstance.TotalMutedTests), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunSkippedSum, float64(runInstance.TotalSkipped), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunFlakyTestsSum, float64(runInstance.TotalFlakyTests), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunDurationSum, float64(runInstance.TotalDuration), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunStartTimeSum, float64(runInstance.StartTime.Unix()), evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
 
 			//Count number of scraped runs
-			c.runSummary.Add(c.CypressRunCount, 1.0, evaluateLabels(RunInstanceOrderedLabels, *metrics, runInstance)...)
+			c.runSummary.Add(c.CypressRunCount, 1.0, evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...)
+			c.observeWithExemplar(c.runDurationHistogram.With(evaluateLabels(c.runInstanceLabels, *metrics, runInstance)...), msToSec(float64(runInstance.TotalDuration)), *metrics, runInstance)
 
 			c.AlreadyProcessedBuilds.Add(runInstance.BuildNumber)
+			c.TotalAnalysedBuilds++
+			c.TotalAnalysedTests += len(runInstance.TestResults.Nodes)
+			if runInstance.BuildNumber > c.LastBuild {
+				c.LastBuild = runInstance.BuildNumber
+			}
+			c.self.runsProcessed.Inc()
+			c.self.testsProcessed.Add(float64(len(runInstance.TestResults.Nodes)))
+			c.self.lastBuild.Set(float64(c.LastBuild))
 
 			for _, testInstance := range runInstance.TestResults.Nodes {
 				state := testInstance.State
 
-				c.testLatest.Add(c.CypressTestDurationLast, testInstance.Duration, evaluateLabels(TestInstanceOrderedLabels, *metrics, testContext{runInstance, testInstance})...)
+				c.testLatest.AddAt(c.CypressTestDurationLast, float64(testInstance.Duration), completedAt, evaluateLabels(c.testLabels, *metrics, testContext{runInstance, testInstance})...)
 
 				matched := false
 				for _, value := range cypressclient.AllValidState() {
@@ -232,43 +680,36 @@ func (c *CypressDashboardCollector) Collect(ch chan<- prometheus.Metric) {
 					if s == 1.0 {
 						matched = true
 					}
-					c.testSummary.Add(c.CypressTestStateSum, s, evaluateLabels(TestResultInstanceOrderedLabels(value.String()), *metrics, testContext{runInstance, testInstance})...)
-					c.testLatest.Add(c.CypressTestStateLast, s, evaluateLabels(TestResultInstanceOrderedLabels(value.String()), *metrics, testContext{runInstance, testInstance})...)
+					var stateExemplar *prometheus.Exemplar
+					if value == cypressclient.Failed && s == 1.0 {
+						stateExemplar = c.runExemplar(*metrics, runInstance, s)
+					}
+					c.testSummary.AddWithExemplar(c.CypressTestStateSum, s, stateExemplar, evaluateLabels(withState(c.testLabels, value.String()), *metrics, testContext{runInstance, testInstance})...)
+					c.testLatest.AddAt(c.CypressTestStateLast, s, completedAt, evaluateLabels(withState(c.testLabels, value.String()), *metrics, testContext{runInstance, testInstance})...)
 				}
 				if !matched {
 					logrus.Warnln("Unknown state", state, " while processing test", testInstance.TitleParts)
-					c.testSummary.Add(c.CypressTestStateSum, 1.0, evaluateLabels(TestResultInstanceOrderedLabels(cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
-					c.testLatest.Add(c.CypressTestStateLast, 1.0, evaluateLabels(TestResultInstanceOrderedLabels(cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
+					c.testSummary.Add(c.CypressTestStateSum, 1.0, evaluateLabels(withState(c.testLabels, cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
+					c.testLatest.AddAt(c.CypressTestStateLast, 1.0, completedAt, evaluateLabels(withState(c.testLabels, cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
 				} else {
-					c.testSummary.Add(c.CypressTestStateSum, 0.0, evaluateLabels(TestResultInstanceOrderedLabels(cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
-					c.testLatest.Add(c.CypressTestStateLast, 0.0, evaluateLabels(TestResultInstanceOrderedLabels(cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
+					c.testSummary.Add(c.CypressTestStateSum, 0.0, evaluateLabels(withState(c.testLabels, cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
+					c.testLatest.AddAt(c.CypressTestStateLast, 0.0, completedAt, evaluateLabels(withState(c.testLabels, cypressclient.Other.String()), *metrics, testContext{runInstance, testInstance})...)
 				}
 
-				c.testSummary.Add(c.CypressTestDurationSum, testInstance.Duration, evaluateLabels(TestInstanceOrderedLabels, *metrics, testContext{runInstance, testInstance})...)
-				c.testSummary.Add(c.CypressTestCount, 1.0, evaluateLabels(TestInstanceOrderedLabels, *metrics, testContext{runInstance, testInstance})...)
+				c.testSummary.Add(c.CypressTestDurationSum, float64(testInstance.Duration), evaluateLabels(c.testLabels, *metrics, testContext{runInstance, testInstance})...)
+				c.testSummary.Add(c.CypressTestCount, 1.0, evaluateLabels(c.testLabels, *metrics, testContext{runInstance, testInstance})...)
+				c.observeWithExemplar(c.testDurationHistogram.With(evaluateLabels(c.testHistogramLabels, *metrics, testContext{runInstance, testInstance})...), msToSec(float64(testInstance.Duration)), *metrics, runInstance)
 			}
-			logrus.Debugln("Map of tests and runs : %+v\n%+v\n%+v\n%+v", c.runLatest, c.runSummary, c.testLatest, c.testSummary)
+			c.processSpecs(*metrics, runInstance)
+			c.processParallelization(*metrics, runInstance)
+			c.processOverhead(*metrics, runInstance)
+			c.processAggregations(*metrics, runInstance)
+			logrus.Debugf("Map of tests and runs : %+v\n%+v\n%+v\n%+v", c.runLatest, c.runSummary, c.testLatest, c.testSummary)
 
 		} else {
-			logrus.Infof("Run %v is in state %v, skipping for now...", runInstance.BuildNumber)
+			logrus.Infof("Run %v is in state %v, skipping for now...", runInstance.BuildNumber, runInstance.Status)
+			c.self.runsSkipped.WithLabelValues(skippedNotCompleted).Inc()
 		}
 
 	}
-	for key, value := range c.runSummary.Map() {
-		logrus.Debugln("Processing summary ( counters )", key.Prom.String())
-		maybeMetric(ch, key.Prom, prometheus.CounterValue, value.Value, noopTransformer, value.Labels)
-	}
-	for key, value := range c.runLatest.Map() {
-		logrus.Debugln("Processing latests ( gauge )", key.Prom.String())
-		maybeMetric(ch, key.Prom, prometheus.GaugeValue, value.Value, noopTransformer, value.Labels)
-	}
-
-	for key, value := range c.testSummary.Map() {
-		logrus.Debugln("Processing tests summary ( counters )", key.Prom.String())
-		maybeMetric(ch, key.Prom, prometheus.CounterValue, value.Value, noopTransformer, value.Labels)
-	}
-	for key, value := range c.testLatest.Map() {
-		logrus.Debugln("Processing test latests ( gauge )", key.Prom.String())
-		maybeMetric(ch, key.Prom, prometheus.GaugeValue, value.Value, noopTransformer, value.Labels)
-	}
 }
@@ -0,0 +1,267 @@
+package cypresscollector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestCypressDashboardCollector_Run_canceled(t *testing.T) {
+	// The dashboard never answers, until the request is canceled
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body must be read for the server to notice the canceled request
+		io.Copy(io.Discard, r.Body)
+		received <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewCypressDashboardCollector(*endpoint, "project", "", "", int64(time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx, time.Hour)
+		close(done)
+	}()
+
+	<-received
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CypressDashboardCollector.Run() should return once canceled")
+	}
+	if err := c.Ready(time.Hour); !errors.Is(err, errNeverSynced) || errors.Unwrap(err) != nil {
+		t.Errorf("CypressDashboardCollector.Ready() = %v, a canceled sync shouldn't be recorded", err)
+	}
+}
+
+func TestCypressDashboardCollector_Sync_timeout(t *testing.T) {
+	// The dashboard never answers, until the request is canceled
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewCypressDashboardCollector(*endpoint, "project", "", "", int64(time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Sync(ctx); err == nil {
+		t.Fatal("CypressDashboardCollector.Sync() should fail past its deadline")
+	}
+	if err := c.Ready(time.Hour); errors.Unwrap(err) == nil {
+		t.Errorf("CypressDashboardCollector.Ready() = %v, the timeout should be recorded as the last error", err)
+	}
+}
+
+func TestCypressDashboardCollector_Sync_backlogTimeout(t *testing.T) {
+	// The dashboard never answers the backlog, until the request is canceled
+	sizes := make(chan bool, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		backlog := strings.Contains(string(body), fmt.Sprintf(`"perPage":%v`, DefaultBacklog))
+		sizes <- backlog
+		if backlog {
+			<-r.Context().Done()
+			return
+		}
+		w.Write([]byte(dashboardResponse))
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewCypressDashboardCollector(*endpoint, "project", "", "", int64(time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Sync(ctx); err == nil {
+		t.Fatal("CypressDashboardCollector.Sync() should fail past its deadline")
+	}
+	if err := c.Sync(context.Background()); err != nil {
+		t.Fatalf("CypressDashboardCollector.Sync() = %v", err)
+	}
+	if first, second := <-sizes, <-sizes; !first || second {
+		t.Errorf("requested the backlog %v then %v, want true then false", first, second)
+	}
+}
+
+func TestCypressDashboardCollector_Sync_concurrent(t *testing.T) {
+	var inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte(dashboardResponse))
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c, err := NewCypressDashboardCollector(*endpoint, "project", "", "", int64(time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Sync(context.Background()); err != nil {
+				t.Errorf("CypressDashboardCollector.Sync() = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if maxInFlight != 1 {
+		t.Errorf("%v syncs requested the dashboard at the same time, want 1", maxInFlight)
+	}
+}
+
+// dashboardResponse is the answer of the dashboard with a single passed run.
+const dashboardResponse = `{"data": {"project": {"id": "project", "runs": {"totalCount": 1, "nodes": [
+	{"id": "run", "status": "PASSED", "buildNumber": 1, "totalPassed": 1, "totalDuration": 1000,
+	 "testResults": {"nodes": [{"id": "test", "titleParts": ["works"], "state": "PASSED", "duration": 100}]}}
+]}}}}`
+
+func TestCypressDashboardCollector_Run_polling(t *testing.T) {
+	received := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(dashboardResponse))
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	collector, err := NewCypressDashboardCollector(*endpoint, "project", "", "", int64(time.Hour), CollectorOptions{Clock: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.Run(ctx, time.Minute)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// The first sync happens at once, the poller and the sweeper tickers are created by then
+	<-received
+	for c.Tickers() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-received:
+		t.Fatal("CypressDashboardCollector.Run() synced again before the interval")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Advance(time.Minute)
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CypressDashboardCollector.Run() should sync once the interval has elapsed")
+	}
+}
+
+func TestCypressDashboardCollector_processRuns_ties(t *testing.T) {
+	withoutName := []string{"project_id", "os_name", "browser_name", "spec_file", "git_branch", "run_group"}
+	tests := []struct {
+		name         string
+		labels       LabelOptions
+		wantDuration float64
+		wantStates   map[string]float64
+	}{
+		{
+			name:         "Should keep the last value of a test repeated with the same labels",
+			wantDuration: 100,
+			wantStates:   map[string]float64{"PASSED": 1, "FAILED": 0},
+		},
+		{
+			name:         "Should combine the tests of a run once the name is dropped",
+			labels:       LabelOptions{Families: map[LabelFamily][]string{TestFamily: withoutName}},
+			wantDuration: 400,
+			wantStates:   map[string]float64{"PASSED": 1, "FAILED": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), CollectorOptions{Labels: tt.labels})
+			if err != nil {
+				t.Fatal(err)
+			}
+			passed := testOnInstance("1", "login.spec.js")
+			passed.TitleParts = []string{"works"}
+			passed.State = cypressclient.Passed.String()
+			passed.Duration = 100
+			failed := testOnInstance("1", "login.spec.js")
+			failed.TitleParts = []string{"fails"}
+			failed.State = cypressclient.Failed.String()
+			failed.Duration = 200
+			run := cypressclient.RunResult{Status: "PASSED", CompletedAt: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)}
+			run.TestResults.Nodes = []cypressclient.TestResult{passed, failed, passed}
+			stats := cypressclient.StatsFromCypressDashboard{}
+			stats.Data.Project.Runs.Nodes = []cypressclient.RunResult{run}
+			c.processRuns(&stats)
+
+			// The values of the passed test, or of all the tests once the name is dropped
+			name := len(c.testLabels)
+			for i, label := range labelsInOrder(c.testLabels) {
+				if label == "name" {
+					name = i
+				}
+			}
+			states := map[string]float64{}
+			for k, v := range c.testLatest.Map() {
+				if name < len(c.testLabels) && v.Labels[name] != "works" {
+					continue
+				}
+				if k.Prom == c.CypressTestDurationLast && v.Value != tt.wantDuration {
+					t.Errorf("cypress_test_duration_seconds_last = %v, want %v", v.Value, tt.wantDuration)
+				}
+				if state := v.Labels[len(v.Labels)-1]; k.Prom == c.CypressTestStateLast && (state == "PASSED" || state == "FAILED") {
+					states[state] = v.Value
+				}
+			}
+			if !reflect.DeepEqual(states, tt.wantStates) {
+				t.Errorf("cypress_test_state_last by state = %v, want %v", states, tt.wantStates)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+)
+
+// FlakinessOptions enables cypress_test_flaky_ratio and cypress_test_failure_ratio, the ratios of flaky and failed
+// results of each test over its last runs. eg, over the last 20 runs of main :
+//
+//	FlakinessOptions{Branch: "main", Runs: 20}
+type FlakinessOptions struct {
+	// Only the runs of this branch are counted, all the branches if empty
+	Branch string
+	// Number of runs of the test counted, unlimited if 0
+	Runs int
+	// Runs counted, before the latest run of the test, unlimited if 0
+	Window time.Duration
+}
+
+func (o FlakinessOptions) Enabled() bool {
+	return o.Runs > 0 || o.Window > 0
+}
+
+func (o FlakinessOptions) Validate() error {
+	if o.Runs < 0 {
+		return fmt.Errorf("the number of runs of the flakiness must be positive, got %v", o.Runs)
+	}
+	if o.Window < 0 {
+		return fmt.Errorf("the window of the flakiness must be positive, got %v", o.Window)
+	}
+	if o.Branch != "" && !o.Enabled() {
+		return fmt.Errorf("the flakiness of branch %v requires a number of runs or a window", o.Branch)
+	}
+	return nil
+}
+
+// newFlakiness creates the flakiness ratios, if enabled by the options.
+func (c *CypressDashboardCollector) newFlakiness(opts FlakinessOptions) {
+	if !opts.Enabled() {
+		return
+	}
+	c.CypressTestFlakyRatio = c.metricNames.desc("cypress_test_flaky_ratio", "", "Ratio of the recent runs where a test was flaky", labelsInOrder(c.testLabels), noopTransformer)
+	c.CypressTestFailureRatio = c.metricNames.desc("cypress_test_failure_ratio", "", "Ratio of the recent runs where a test failed", labelsInOrder(c.testLabels), noopTransformer)
+	c.testFlakyRatio.Aggregation = metricsmap.SlidingMean(opts.Window, opts.Runs)
+	c.testFailureRatio.Aggregation = metricsmap.SlidingMean(opts.Window, opts.Runs)
+}
+
+// processFlakiness counts the tests of the run that passed or failed. The other ones, eg skipped, weren't run.
+// Results with the same labels, eg the test on several browsers once browser_name is dropped, are a single run of the
+// test : flaky or failed if any of them was.
+func (c *CypressDashboardCollector) processFlakiness(metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult) {
+	if c.CypressTestFlakyRatio == nil {
+		return
+	}
+	if branch := c.opts.Aggregations.Flakiness.Branch; branch != "" && run.Commit.Branch != branch {
+		return
+	}
+	type outcome struct {
+		labels        []string
+		flaky, failed float64
+	}
+	outcomes := []*outcome{}
+	byLabels := map[string]*outcome{}
+	for _, test := range run.TestResults.Nodes {
+		if test.State != cypressclient.Passed.String() && test.State != cypressclient.Failed.String() {
+			continue
+		}
+		labels := evaluateLabels(c.testLabels, metrics, testContext{run, test})
+		key := strings.Join(labels, "\x00")
+		o, ok := byLabels[key]
+		if !ok {
+			o = &outcome{labels: labels}
+			byLabels[key] = o
+			outcomes = append(outcomes, o)
+		}
+		o.flaky = math.Max(o.flaky, promValueFromBool(test.IsFlaky))
+		o.failed = math.Max(o.failed, promValueFromState(test.State, cypressclient.Failed.String()))
+	}
+
+	completedAt := runCompletedAt(run)
+	for _, o := range outcomes {
+		c.testFlakyRatio.AddAt(c.CypressTestFlakyRatio, o.flaky, completedAt, o.labels...)
+		c.testFailureRatio.AddAt(c.CypressTestFailureRatio, o.failed, completedAt, o.labels...)
+	}
+}
@@ -0,0 +1,112 @@
+package cypresscollector
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+)
+
+func TestFlakinessOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    FlakinessOptions
+		wantErr bool
+	}{
+		{"Should accept no flakiness", FlakinessOptions{}, false},
+		{"Should accept a number of runs on a branch", FlakinessOptions{Branch: "main", Runs: 20}, false},
+		{"Should accept a window", FlakinessOptions{Window: 7 * 24 * time.Hour}, false},
+		{"Should refuse a negative number of runs", FlakinessOptions{Runs: -1}, true},
+		{"Should refuse a negative window", FlakinessOptions{Window: -time.Hour}, true},
+		{"Should refuse a branch without runs nor window", FlakinessOptions{Branch: "main"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("FlakinessOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCypressDashboardCollector_processFlakiness(t *testing.T) {
+	opts := CollectorOptions{Aggregations: AggregationOptions{Flakiness: FlakinessOptions{Branch: "main", Runs: 4}}}
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	completedAt := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	results := []struct {
+		branch string
+		state  string
+		flaky  bool
+	}{
+		// Out of the last 4 runs
+		{"main", "FAILED", false},
+		{"main", "PASSED", true},
+		{"main", "PASSED", false},
+		{"main", "FAILED", false},
+		{"main", "PASSED", true},
+		// Not counted
+		{"feature", "FAILED", false},
+		{"main", "SKIPPED", false},
+	}
+	for i, r := range results {
+		run := cypressclient.RunResult{CompletedAt: completedAt.Add(time.Duration(i) * time.Hour)}
+		run.Commit.Branch = r.branch
+		run.TestResults.Nodes = []cypressclient.TestResult{{TitleParts: []string{"works"}, State: r.state, IsFlaky: r.flaky}}
+		c.processFlakiness(cypressclient.StatsFromCypressDashboard{}, run)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		values map[metricsmap.Key]metricsmap.Value[float64]
+		want   float64
+	}{
+		{"cypress_test_flaky_ratio", c.testFlakyRatio.Map(), 0.5},
+		{"cypress_test_failure_ratio", c.testFailureRatio.Map(), 0.25},
+	} {
+		if len(tt.values) != 1 {
+			t.Fatalf("%v has %v series, want 1 for the test on main", tt.name, len(tt.values))
+		}
+		for _, v := range tt.values {
+			if v.Value != tt.want {
+				t.Errorf("%v%v = %v, want %v", tt.name, v.Labels, v.Value, tt.want)
+			}
+		}
+	}
+}
+
+func TestCypressDashboardCollector_processFlakiness_droppedBrowser(t *testing.T) {
+	opts := CollectorOptions{
+		Labels:       LabelOptions{Families: map[LabelFamily][]string{TestFamily: {"project_id", "name"}}},
+		Aggregations: AggregationOptions{Flakiness: FlakinessOptions{Runs: 2}},
+	}
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	onBrowser := func(browser, state string) cypressclient.TestResult {
+		test := cypressclient.TestResult{TitleParts: []string{"works"}, State: state}
+		test.Instance.Browser.Name = browser
+		return test
+	}
+	completedAt := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i, states := range [][]string{{"PASSED", "FAILED"}, {"PASSED", "PASSED"}} {
+		run := cypressclient.RunResult{CompletedAt: completedAt.Add(time.Duration(i) * time.Hour)}
+		run.TestResults.Nodes = []cypressclient.TestResult{onBrowser("chrome", states[0]), onBrowser("firefox", states[1])}
+		c.processFlakiness(cypressclient.StatsFromCypressDashboard{}, run)
+	}
+
+	// The last 2 runs, not the last 2 results which both passed
+	if len(c.testFailureRatio.Map()) != 1 {
+		t.Fatalf("cypress_test_failure_ratio has %v series, want 1 for the test on both browsers", len(c.testFailureRatio.Map()))
+	}
+	for _, v := range c.testFailureRatio.Map() {
+		if v.Value != 0.5 {
+			t.Errorf("cypress_test_failure_ratio%v = %v, want 0.5 for a failure in one of the 2 runs", v.Labels, v.Value)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package cypresscollector
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var errNeverSynced = errors.New("the dashboard hasn't been synced yet")
+
+// LastSuccess returns the time of the last successful sync with the dashboard, zero if there was none.
+func (c *CypressDashboardCollector) LastSuccess() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastSuccess
+}
+
+// Ready returns an error if the dashboard wasn't synced successfully during the threshold.
+func (c *CypressDashboardCollector) Ready(threshold time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return readiness(c.lastSuccess, c.lastError, threshold, c.clock.Now())
+}
+
+func readiness(lastSuccess time.Time, lastError error, threshold time.Duration, now time.Time) error {
+	if lastSuccess.IsZero() {
+		if lastError != nil {
+			return fmt.Errorf("%v : %w", errNeverSynced, lastError)
+		}
+		return errNeverSynced
+	}
+	if age := now.Sub(lastSuccess); age > threshold {
+		if lastError != nil {
+			return fmt.Errorf("last successful sync was %v ago : %w", age.Truncate(time.Second), lastError)
+		}
+		return fmt.Errorf("last successful sync was %v ago", age.Truncate(time.Second))
+	}
+	return nil
+}
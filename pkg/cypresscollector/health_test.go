@@ -0,0 +1,35 @@
+package cypresscollector
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_readiness(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	badCredentials := errors.New("bad credentials")
+	tests := []struct {
+		name        string
+		lastSuccess time.Time
+		lastError   error
+		wantErr     bool
+	}{
+		{"Should not be ready before the first sync", time.Time{}, nil, true},
+		{"Should not be ready if the first sync failed", time.Time{}, badCredentials, true},
+		{"Should be ready after a recent sync", now.Add(-time.Minute), nil, false},
+		{"Should stay ready on a recent error", now.Add(-time.Minute), badCredentials, false},
+		{"Should not be ready once the last sync is too old", now.Add(-time.Hour), badCredentials, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readiness(tt.lastSuccess, tt.lastError, 5*time.Minute, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("readiness() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.lastError != nil && err != nil && !errors.Is(err, tt.lastError) {
+				t.Errorf("readiness() error = %v, should wrap %v", err, tt.lastError)
+			}
+		})
+	}
+}
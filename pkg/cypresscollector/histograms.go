@@ -0,0 +1,90 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+)
+
+var (
+	// From 50ms to a bit more than 3 minutes
+	DefaultTestDurationBuckets = prometheus.ExponentialBuckets(0.05, 2, 13)
+	// From 30s to a bit more than 4 hours
+	DefaultRunDurationBuckets = prometheus.ExponentialBuckets(30, 2, 10)
+)
+
+// HistogramOptions configures the duration histograms.
+type HistogramOptions struct {
+	TestDurationBuckets []float64
+	RunDurationBuckets  []float64
+	// Growth factor between two buckets of the native histograms. Native histograms are disabled if 0.
+	NativeBucketFactor float64
+}
+
+func (o HistogramOptions) Validate() error {
+	if o.NativeBucketFactor != 0 && o.NativeBucketFactor <= 1 {
+		return fmt.Errorf("native histogram bucket factor must be greater than 1, got %v", o.NativeBucketFactor)
+	}
+	for _, buckets := range [][]float64{o.TestDurationBuckets, o.RunDurationBuckets} {
+		if !strictlyIncreasing(buckets) {
+			return fmt.Errorf("buckets must be in strictly increasing order, got %v", buckets)
+		}
+	}
+	return nil
+}
+
+func (o HistogramOptions) histogramOpts(name, help string, buckets, defaultBuckets []float64) prometheus.HistogramOpts {
+	if len(buckets) == 0 {
+		buckets = defaultBuckets
+	}
+	return prometheus.HistogramOpts{
+		Name:                        name,
+		Help:                        help,
+		Buckets:                     buckets,
+		NativeHistogramBucketFactor: o.NativeBucketFactor,
+	}
+}
+
+// ParseBuckets parses a comma separated list of bucket upper bounds, in seconds.
+func ParseBuckets(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	res := []float64{}
+	for _, raw := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q : %v", raw, err)
+		}
+		res = append(res, b)
+	}
+	if !strictlyIncreasing(res) {
+		return nil, fmt.Errorf("buckets must be in strictly increasing order, got %v", s)
+	}
+	return res, nil
+}
+
+// strictlyIncreasing tells whether the bucket bounds can be used by a histogram, which refuses repeated bounds.
+func strictlyIncreasing(buckets []float64) bool {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// newHistogramMap tracks the label sets of a histogram, to expire them with the family and count them against its
+// budget. The retention is set by applyRetention.
+func (c *CypressDashboardCollector) newHistogramMap(vec *prometheus.HistogramVec, labels []labelsEvaluatorImpl, family LabelFamily) metricsmap.MetricMapHistogram {
+	return metricsmap.MetricMapHistogram{
+		Vec:     vec,
+		Names:   labelsInOrder(labels),
+		Budget:  c.budgets[family],
+		OnEvict: c.countEvictions(family),
+		Clock:   c.clock,
+	}
+}
@@ -0,0 +1,107 @@
+package cypresscollector
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestParseBuckets(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{"Empty string should use the default buckets", "", nil, false},
+		{"Should parse buckets", "0.5, 1,10", []float64{0.5, 1, 10}, false},
+		{"Should refuse unsorted buckets", "1,0.5", nil, true},
+		{"Should refuse repeated buckets", "0.5,1,1", nil, true},
+		{"Should refuse invalid buckets", "1,abc", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBuckets(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBuckets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBuckets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHistogramOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    HistogramOptions
+		wantErr bool
+	}{
+		{"Should accept the default buckets", HistogramOptions{}, false},
+		{"Should accept increasing buckets", HistogramOptions{TestDurationBuckets: []float64{0.5, 1}, RunDurationBuckets: []float64{60}}, false},
+		{"Should refuse unsorted buckets", HistogramOptions{RunDurationBuckets: []float64{60, 30}}, true},
+		{"Should refuse repeated buckets", HistogramOptions{TestDurationBuckets: []float64{1, 1}}, true},
+		{"Should refuse a bucket factor of 1", HistogramOptions{NativeBucketFactor: 1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("HistogramOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCypressDashboardCollector_histogramsBudget(t *testing.T) {
+	opts := CollectorOptions{Labels: LabelOptions{Limits: map[LabelFamily]int{TestFamily: 3}}}
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := &cypressclient.StatsFromCypressDashboard{}
+	run := cypressclient.RunResult{Status: "PASSED", BuildNumber: 1}
+	for _, spec := range []string{"a", "b", "c", "d"} {
+		test := testOnInstance(spec, spec+".spec.js")
+		test.State = "PASSED"
+		run.TestResults.Nodes = append(run.TestResults.Nodes, test)
+	}
+	metrics.Data.Project.Runs.Nodes = cypressclient.RunResults{run}
+	c.processRuns(metrics)
+
+	if got := c.budgets[TestFamily].Len(); got != 3 {
+		t.Errorf("the test budget counts %v combinations, want its limit", got)
+	}
+	if got := testutil.CollectAndCount(c.CypressTestDurationHistogram); got > 3 {
+		t.Errorf("cypress_test_duration_seconds has %v series, over the limit of the test family", got)
+	}
+}
+
+func TestCypressDashboardCollector_histogramsSeries(t *testing.T) {
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := &cypressclient.StatsFromCypressDashboard{}
+	run := cypressclient.RunResult{Status: "PASSED", BuildNumber: 1}
+	for _, spec := range []string{"a", "b"} {
+		test := testOnInstance(spec, spec+".spec.js")
+		test.State = "PASSED"
+		run.TestResults.Nodes = append(run.TestResults.Nodes, test)
+	}
+	metrics.Data.Project.Runs.Nodes = cypressclient.RunResults{run}
+	c.processRuns(metrics)
+	c.updateSeries()
+
+	want := testutil.CollectAndCount(c.CypressTestDurationHistogram)
+	if want == 0 {
+		t.Fatal("cypress_test_duration_seconds has no series")
+	}
+	if got := testutil.ToFloat64(c.self.series.WithLabelValues("cypress_test_duration_seconds")); got != float64(want) {
+		t.Errorf("the series gauge counts %v series of cypress_test_duration_seconds, want %v", got, want)
+	}
+}
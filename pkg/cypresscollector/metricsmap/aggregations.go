@@ -0,0 +1,293 @@
+package metricsmap
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
+)
+
+// Aggregator computes the value of an entry from the values added to it.
+type Aggregator interface {
+	// Observe adds a value observed at the given time
+	Observe(value float64, at time.Time)
+	// Value returns the aggregated value
+	Value() float64
+}
+
+// Aggregation creates the aggregator of a new entry.
+type Aggregation func() Aggregator
+
+// Max keeps the highest value observed during the window, or since the entry was created if window is 0.
+func Max(window time.Duration) Aggregation {
+	if window > 0 {
+		return newWindowed(window, 0, maxOf)
+	}
+	return func() Aggregator { return &extremum{better: func(a, b float64) bool { return a > b }} }
+}
+
+// Min keeps the lowest value observed during the window, or since the entry was created if window is 0.
+func Min(window time.Duration) Aggregation {
+	if window > 0 {
+		return newWindowed(window, 0, minOf)
+	}
+	return func() Aggregator { return &extremum{better: func(a, b float64) bool { return a < b }} }
+}
+
+// Mean is the mean of all the values observed since the entry was created.
+func Mean() Aggregation {
+	return func() Aggregator { return &mean{} }
+}
+
+// EWMA is the exponentially weighted moving average of the values. Alpha, greater than 0 and at most 1, is the weight
+// of a new value : the higher it is, the faster the average follows the changes. It panics with another alpha.
+func EWMA(alpha float64) Aggregation {
+	if !(alpha > 0 && alpha <= 1) {
+		panic(fmt.Sprintf("metricsmap: the alpha of an EWMA must be in (0, 1], got %v", alpha))
+	}
+	return func() Aggregator { return &ewma{alpha: alpha} }
+}
+
+// Quantile is the q-quantile, between 0 and 1, of the values observed during the window, among the last samples
+// values. A limit of 0 is ignored, but one of them is required. It panics with q outside of [0, 1].
+func Quantile(q float64, window time.Duration, samples int) Aggregation {
+	if !(q >= 0 && q <= 1) {
+		panic(fmt.Sprintf("metricsmap: a quantile must be in [0, 1], got %v", q))
+	}
+	return newWindowed(window, samples, func(values []float64) float64 { return quantile(q, values) })
+}
+
+// SlidingMean is the mean of the values observed during the window, among the last samples values. A limit of 0 is
+// ignored, but one of them is required, eg the ratio of failures over the last 20 runs is SlidingMean(0, 20) of 1 for
+// a failure and 0 otherwise.
+func SlidingMean(window time.Duration, samples int) Aggregation {
+	return newWindowed(window, samples, meanOf)
+}
+
+// newWindowed panics without window nor number of samples, since every value would be kept for the lifetime of the
+// entry.
+func newWindowed(window time.Duration, samples int, reduce func([]float64) float64) Aggregation {
+	if window <= 0 && samples <= 0 {
+		panic("metricsmap: a windowed aggregation requires a window or a number of samples")
+	}
+	return func() Aggregator { return &windowed{window: window, size: samples, reduce: reduce} }
+}
+
+type extremum struct {
+	value  float64
+	set    bool
+	better func(a, b float64) bool
+}
+
+func (e *extremum) Observe(value float64, _ time.Time) {
+	if !e.set || e.better(value, e.value) {
+		e.value = value
+		e.set = true
+	}
+}
+
+func (e *extremum) Value() float64 {
+	return e.value
+}
+
+type mean struct {
+	value float64
+	count int
+}
+
+func (m *mean) Observe(value float64, _ time.Time) {
+	m.count++
+	// Incremental mean, which doesn't overflow like a sum
+	m.value += (value - m.value) / float64(m.count)
+}
+
+func (m *mean) Value() float64 {
+	return m.value
+}
+
+type ewma struct {
+	alpha float64
+	value float64
+	set   bool
+}
+
+func (e *ewma) Observe(value float64, _ time.Time) {
+	if !e.set {
+		e.value = value
+		e.set = true
+		return
+	}
+	e.value = e.alpha*value + (1-e.alpha)*e.value
+}
+
+func (e *ewma) Value() float64 {
+	return e.value
+}
+
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+// windowed keeps the values observed during the window, which ends with the most recent observation, and at most
+// size values. An entry that isn't updated anymore keeps its last window, until it expires from its map.
+type windowed struct {
+	window  time.Duration
+	size    int
+	samples []sample
+	reduce  func([]float64) float64
+}
+
+func (w *windowed) Observe(value float64, at time.Time) {
+	// Values can be observed out of order, insert them after the ones observed at the same time or before
+	i := sort.Search(len(w.samples), func(i int) bool { return w.samples[i].at.After(at) })
+	w.samples = append(w.samples, sample{})
+	copy(w.samples[i+1:], w.samples[i:])
+	w.samples[i] = sample{value, at}
+	if w.window > 0 {
+		oldest := w.samples[len(w.samples)-1].at.Add(-w.window)
+		w.samples = w.samples[sort.Search(len(w.samples), func(i int) bool { return !w.samples[i].at.Before(oldest) }):]
+	}
+	if w.size > 0 && len(w.samples) > w.size {
+		w.samples = w.samples[len(w.samples)-w.size:]
+	}
+}
+
+func (w *windowed) Value() float64 {
+	values := make([]float64, len(w.samples))
+	for i, s := range w.samples {
+		values[i] = s.value
+	}
+	return w.reduce(values)
+}
+
+func maxOf(values []float64) float64 {
+	res := math.Inf(-1)
+	for _, v := range values {
+		res = math.Max(res, v)
+	}
+	return res
+}
+
+// meanOf returns NaN without values.
+func meanOf(values []float64) float64 {
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+func minOf(values []float64) float64 {
+	res := math.Inf(1)
+	for _, v := range values {
+		res = math.Min(res, v)
+	}
+	return res
+}
+
+// quantile interpolates linearly between the closest ranks, NaN without values.
+func quantile(q float64, values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	sort.Float64s(values)
+	rank := q * float64(len(values)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return values[lower] + (values[upper]-values[lower])*(rank-float64(lower))
+}
+
+// For Gauge value, aggregated over time, eg the max or the moving average
+type MetricMapAggregate struct {
+	metrics map[Key]Value[float64]
+	// Aggregators of the entries, removed along with them
+	aggregators map[Key]Aggregator
+	Aggregation Aggregation
+	KeepUntil   time.Duration
+	// Maximum number of entries, the least recently updated one is evicted to make room for a new one. Unlimited if 0.
+	MaxEntries int
+	// Limits the label combinations, shared with the other maps of the family. Unlimited if nil.
+	Budget *Budget
+	// Called for each evicted entry, with the reason of the eviction
+	OnEvict func(reason string)
+	// Time of the updates, the system clock if nil
+	Clock   clock.Clock
+	recency recency
+}
+
+func (m *MetricMapAggregate) Add(k *prometheus.Desc, value float64, labels ...string) {
+	m.AddAt(k, value, time.Time{}, labels...)
+}
+
+// AddAt aggregates a value observed at the given timestamp, or now if it's zero. The entry has the timestamp of the
+// most recent observation.
+func (m *MetricMapAggregate) AddAt(k *prometheus.Desc, value float64, timestamp time.Time, labels ...string) {
+	if m.metrics == nil {
+		m.metrics = map[Key]Value[float64]{}
+		m.aggregators = map[Key]Aggregator{}
+	}
+
+	labels = m.Budget.admit(nil, labels)
+	labelsHash := StringSliceHash(labels)
+	key := Key{
+		k,
+		labelsHash,
+	}
+	observedAt := timestamp
+	if observedAt.IsZero() {
+		observedAt = clock.OrReal(m.Clock).Now()
+	}
+	if currentValue, ok := m.metrics[key]; ok {
+		if !sameLabels(currentValue.Labels, labels) {
+			hashCollision(k, currentValue.Labels, labels)
+			return
+		}
+		if timestamp.Before(currentValue.Timestamp) {
+			timestamp = currentValue.Timestamp
+		}
+	} else {
+		evictLeastRecent(m.metrics, &m.recency, m.MaxEntries, m.Budget, nil, m.OnEvict)
+		m.forgetEvicted()
+		m.Budget.acquire(nil, labels)
+		m.aggregators[key] = m.Aggregation()
+	}
+	aggregator := m.aggregators[key]
+	aggregator.Observe(value, observedAt)
+	m.metrics[key] = Value[float64]{aggregator.Value(), labels, clock.OrReal(m.Clock).Now(), timestamp, nil}
+	m.recency.touch(key)
+}
+
+func (m MetricMapAggregate) Get(k Key) (*Value[float64], error) {
+	if v, ok := m.metrics[k]; ok {
+		return &v, nil
+	}
+	return nil, keyNotFoundError(k)
+}
+
+// Map returns the map. Expired entries are only removed by Sweep.
+func (m MetricMapAggregate) Map() map[Key]Value[float64] {
+	return m.metrics
+}
+
+// Sweep removes the entries that weren't updated for KeepUntil, and returns their number.
+func (m *MetricMapAggregate) Sweep(now time.Time) int {
+	evicted := freeOldItems(m.metrics, &m.recency, m.KeepUntil, m.Budget, nil, m.OnEvict, now)
+	m.forgetEvicted()
+	return evicted
+}
+
+// forgetEvicted removes the aggregators of the entries that have been evicted.
+func (m *MetricMapAggregate) forgetEvicted() {
+	if len(m.aggregators) == len(m.metrics) {
+		return
+	}
+	for k := range m.aggregators {
+		if _, ok := m.metrics[k]; !ok {
+			delete(m.aggregators, k)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package metricsmap
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAggregations(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time { return start.Add(time.Duration(hours) * time.Hour) }
+	tests := []struct {
+		name        string
+		aggregation Aggregation
+		values      []float64
+		times       []time.Time
+		want        float64
+	}{
+		{"Max", Max(0), []float64{3, 7, 5}, []time.Time{at(0), at(1), at(2)}, 7},
+		{"Max over a window", Max(time.Hour), []float64{9, 3, 5}, []time.Time{at(0), at(2), at(3)}, 5},
+		{"Max out of order", Max(time.Hour), []float64{3, 5, 9}, []time.Time{at(2), at(3), at(0)}, 5},
+		{"Min", Min(0), []float64{3, 1, 5}, []time.Time{at(0), at(1), at(2)}, 1},
+		{"Min over a window", Min(time.Hour), []float64{1, 3, 5}, []time.Time{at(0), at(2), at(3)}, 3},
+		{"Mean", Mean(), []float64{1, 2, 6}, []time.Time{at(0), at(1), at(2)}, 3},
+		{"EWMA starts with the first value", EWMA(0.5), []float64{4}, []time.Time{at(0)}, 4},
+		{"EWMA", EWMA(0.5), []float64{4, 8, 0}, []time.Time{at(0), at(1), at(2)}, 3},
+		{"Median", Quantile(0.5, 24*time.Hour, 0), []float64{5, 1, 3}, []time.Time{at(0), at(1), at(2)}, 3},
+		{"Interpolated quantile", Quantile(0.9, 24*time.Hour, 0), []float64{1, 2, 3, 4, 5}, []time.Time{at(0), at(1), at(2), at(3), at(4)}, 4.6},
+		{"Quantile over a window", Quantile(1, 2*time.Hour, 0), []float64{10, 1, 2, 3}, []time.Time{at(0), at(3), at(4), at(5)}, 3},
+		{"Quantile of the last samples", Quantile(0, 0, 2), []float64{1, 5, 3}, []time.Time{at(0), at(1), at(2)}, 3},
+		{"Quantile of the last samples out of order", Quantile(1, 0, 2), []float64{9, 3, 5}, []time.Time{at(2), at(3), at(0)}, 9},
+		{"Mean over the last samples", SlidingMean(0, 2), []float64{1, 0, 1, 1}, []time.Time{at(0), at(1), at(2), at(3)}, 1},
+		{"Mean over a window", SlidingMean(2*time.Hour, 0), []float64{1, 1, 0, 0}, []time.Time{at(0), at(1), at(2), at(3)}, 1.0 / 3},
+		{"Mean over a window and samples", SlidingMean(2*time.Hour, 2), []float64{1, 1, 0, 0}, []time.Time{at(0), at(1), at(2), at(3)}, 0},
+		{"Mean of the last samples out of order", SlidingMean(0, 2), []float64{0, 1, 1}, []time.Time{at(2), at(0), at(1)}, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := tt.aggregation()
+			for i, v := range tt.values {
+				a.Observe(v, tt.times[i])
+			}
+			if got := a.Value(); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Value() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregations_unbounded(t *testing.T) {
+	for name, aggregation := range map[string]func(){
+		"Quantile":    func() { Quantile(0.5, 0, 0) },
+		"SlidingMean": func() { SlidingMean(0, 0) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v without window nor samples should panic, it would keep every value", name)
+				}
+			}()
+			aggregation()
+		})
+	}
+}
+
+func TestAggregations_invalid(t *testing.T) {
+	for name, aggregation := range map[string]func(){
+		"Quantile above 1":    func() { Quantile(1.5, time.Hour, 0) },
+		"Negative quantile":   func() { Quantile(-0.1, time.Hour, 0) },
+		"NaN quantile":        func() { Quantile(math.NaN(), time.Hour, 0) },
+		"EWMA with alpha 0":   func() { EWMA(0) },
+		"EWMA with alpha > 1": func() { EWMA(1.5) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%v should panic at construction, rather than on the first value", name)
+				}
+			}()
+			aggregation()
+		})
+	}
+}
+
+func TestMetricMapAggregate(t *testing.T) {
+	desc := prometheus.NewDesc("duration", "", []string{"test"}, nil)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := MetricMapAggregate{Aggregation: Max(0)}
+	m.AddAt(desc, 3, start, "a")
+	m.AddAt(desc, 5, start.Add(time.Hour), "a")
+	m.AddAt(desc, 4, start, "a")
+	m.AddAt(desc, 1, start, "b")
+
+	if len(m.Map()) != 2 {
+		t.Fatalf("Map() = %v, want 2 entries", m.Map())
+	}
+	v, err := m.Get(Key{desc, StringSliceHash([]string{"a"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Value != 5 || !v.Timestamp.Equal(start.Add(time.Hour)) {
+		t.Errorf("Get() = %v at %v, want 5 at %v", v.Value, v.Timestamp, start.Add(time.Hour))
+	}
+}
+
+func TestMetricMapAggregate_Sweep(t *testing.T) {
+	desc := prometheus.NewDesc("duration", "", []string{"test"}, nil)
+	m := MetricMapAggregate{Aggregation: Max(0), KeepUntil: time.Hour}
+	m.Add(desc, 5, "a")
+	if evicted := m.Sweep(time.Now().Add(2 * time.Hour)); evicted != 1 {
+		t.Fatalf("Sweep() = %v, want 1", evicted)
+	}
+	// The aggregator is removed along with the entry, a new entry starts from scratch
+	m.Add(desc, 2, "a")
+	if v, _ := m.Get(Key{desc, StringSliceHash([]string{"a"})}); v == nil || v.Value != 2 {
+		t.Errorf("Get() = %v, want 2", v)
+	}
+	if len(m.aggregators) != 1 {
+		t.Errorf("%v aggregators, want 1", len(m.aggregators))
+	}
+}
+
+func TestMetricMapAggregate_MaxEntries(t *testing.T) {
+	desc := prometheus.NewDesc("duration", "", []string{"test"}, nil)
+	evictions := 0
+	m := MetricMapAggregate{Aggregation: Mean(), MaxEntries: 1, OnEvict: func(string) { evictions++ }}
+	m.Add(desc, 5, "a")
+	m.Add(desc, 2, "b")
+	if len(m.Map()) != 1 || len(m.aggregators) != 1 || evictions != 1 {
+		t.Errorf("%v entries, %v aggregators and %v evictions, want 1 of each", len(m.Map()), len(m.aggregators), evictions)
+	}
+}
@@ -0,0 +1,271 @@
+package metricsmap
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Overflow is the label value of the combinations that didn't fit in their budget.
+const Overflow = "__overflow__"
+
+// Budget limits the number of label combinations of a family of metrics. It's shared by the maps of the family :
+// a combination is counted as long as one of the maps holds a value with it. Once the limit is reached, the values
+// with new combinations are folded into a single combination, where the labels are replaced by Overflow.
+type Budget struct {
+	// Maximum number of label combinations, including the overflows, unlimited if 0 or less. Each value of the kept
+	// labels reserves a combination for its overflow : the limit is only exceeded with more values of the kept labels,
+	// eg projects, than the limit.
+	Limit int
+	// Names of the labels of the family, in order. The labels of a value start with them, the following ones, eg the
+	// state of a test, are variants of the same combination.
+	Names []string
+	// Indexes of the labels kept when folding into the overflow, eg the project, so that the overflows of the
+	// projects don't collide
+	Keep []int
+
+	mu           sync.Mutex
+	combinations map[labelsHash]*combination
+	// Number of combinations which are overflows
+	overflows int
+	// Number of combinations of each value of the kept labels
+	keptValues map[labelsHash]int
+	// Combinations folded into the overflow since the last call to TakeDropped
+	dropped map[labelsHash]struct{}
+}
+
+type combination struct {
+	labels []string
+	// Number of keys of the maps using the combination
+	refs     int
+	overflow bool
+}
+
+// combination returns the labels identifying the combination of a value. names are the names of its labels, for the
+// values which don't start with the labels of the family, eg a histogram leaving some of them out : the missing
+// labels are empty, and the labels unknown to the family are part of the combination. nil otherwise.
+func (b *Budget) combination(names, labels []string) []string {
+	res := make([]string, 0, len(b.Names)+len(labels))
+	if names == nil {
+		res = append(res, labels...)
+		if len(b.Names) > 0 && len(res) > len(b.Names) {
+			res = res[:len(b.Names)]
+		}
+	} else {
+		res = res[:len(b.Names)]
+		for i, name := range names {
+			if j := indexOf(b.Names, name); j >= 0 {
+				res[j] = labels[i]
+			} else {
+				res = append(res, labels[i])
+			}
+		}
+	}
+	if b.overflow(names, labels) {
+		// The overflows of all the maps are the same combination
+		for i := range res {
+			if !b.kept(nil, i) {
+				res[i] = Overflow
+			}
+		}
+		if len(b.Names) > 0 {
+			res = res[:len(b.Names)]
+		}
+	}
+	return res
+}
+
+// overflow returns whether the labels of a value have been folded into the overflow.
+func (b *Budget) overflow(names, labels []string) bool {
+	folded := false
+	for i, l := range labels {
+		if b.kept(names, i) || b.variant(names, i) {
+			continue
+		}
+		if l != Overflow {
+			return false
+		}
+		folded = true
+	}
+	return folded
+}
+
+// kept returns whether the i-th label of a value is kept when folding it into the overflow.
+func (b *Budget) kept(names []string, i int) bool {
+	for _, k := range b.Keep {
+		if names == nil && k == i || names != nil && k < len(b.Names) && names[i] == b.Names[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// variant returns whether the i-th label of a value is a variant of its combination, eg the state of a test.
+func (b *Budget) variant(names []string, i int) bool {
+	return names == nil && len(b.Names) > 0 && i >= len(b.Names)
+}
+
+// fold replaces the labels of a value which are part of its combination by Overflow.
+func (b *Budget) fold(names, labels []string) []string {
+	res := make([]string, len(labels))
+	for i := range labels {
+		if b.kept(names, i) || b.variant(names, i) {
+			res[i] = labels[i]
+		} else {
+			res[i] = Overflow
+		}
+	}
+	return res
+}
+
+// keptHash identifies the values of the kept labels of a combination.
+func (b *Budget) keptHash(combination []string) labelsHash {
+	kept := make([]string, 0, len(b.Keep))
+	for _, k := range b.Keep {
+		if k < len(combination) {
+			kept = append(kept, combination[k])
+		}
+	}
+	return StringSliceHash(kept)
+}
+
+// admit returns the labels to store a value with : its own labels if they fit in the budget, the overflow otherwise.
+// A combination of the limit is reserved for the overflow of each value of the kept labels.
+func (b *Budget) admit(names, labels []string) []string {
+	if b == nil || b.Limit <= 0 {
+		return labels
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := b.combination(names, labels)
+	hash := StringSliceHash(key)
+	if _, ok := b.combinations[hash]; ok {
+		return labels
+	}
+	reserved := len(b.keptValues)
+	if _, ok := b.keptValues[b.keptHash(key)]; !ok {
+		reserved++
+	}
+	if len(b.combinations)-b.overflows+1+reserved <= b.Limit {
+		return labels
+	}
+	if b.dropped == nil {
+		b.dropped = map[labelsHash]struct{}{}
+	}
+	b.dropped[hash] = struct{}{}
+	return b.fold(names, labels)
+}
+
+// acquire counts a new key of a map using the labels.
+func (b *Budget) acquire(names, labels []string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.combinations == nil {
+		b.combinations = map[labelsHash]*combination{}
+		b.keptValues = map[labelsHash]int{}
+	}
+	key := b.combination(names, labels)
+	hash := StringSliceHash(key)
+	if c, ok := b.combinations[hash]; ok {
+		c.refs++
+		return
+	}
+	c := &combination{labels: key, refs: 1, overflow: b.overflow(names, labels)}
+	if c.overflow {
+		b.overflows++
+	}
+	b.keptValues[b.keptHash(key)]++
+	b.combinations[hash] = c
+}
+
+// release forgets a key of a map using the labels. The combination is freed once no key uses it anymore.
+func (b *Budget) release(names, labels []string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	hash := StringSliceHash(b.combination(names, labels))
+	if c, ok := b.combinations[hash]; ok {
+		c.refs--
+		if c.refs <= 0 {
+			delete(b.combinations, hash)
+			if c.overflow {
+				b.overflows--
+			}
+			kept := b.keptHash(c.labels)
+			if b.keptValues[kept]--; b.keptValues[kept] <= 0 {
+				delete(b.keptValues, kept)
+			}
+		}
+	}
+}
+
+// Len returns the number of label combinations in use, including the overflow.
+func (b *Budget) Len() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.combinations)
+}
+
+// TakeDropped returns the number of label combinations folded into the overflow since its last call.
+func (b *Budget) TakeDropped() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := len(b.dropped)
+	b.dropped = nil
+	return res
+}
+
+// WorstLabels returns the n labels with the most distinct values among the combinations in use, with their number
+// of values, eg `name (1234)`. They're the ones to drop to fit in the budget.
+func (b *Budget) WorstLabels(n int) []string {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	values := make([]map[string]struct{}, len(b.Names))
+	for i := range values {
+		values[i] = map[string]struct{}{}
+	}
+	for _, c := range b.combinations {
+		for i := 0; i < len(c.labels) && i < len(b.Names); i++ {
+			values[i][c.labels[i]] = struct{}{}
+		}
+	}
+	b.mu.Unlock()
+
+	indexes := make([]int, len(b.Names))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return len(values[indexes[i]]) > len(values[indexes[j]])
+	})
+	res := []string{}
+	for _, i := range indexes {
+		if len(res) == n {
+			break
+		}
+		res = append(res, fmt.Sprintf("%v (%v)", b.Names[i], len(values[i])))
+	}
+	return res
+}
+
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
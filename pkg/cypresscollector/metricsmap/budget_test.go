@@ -0,0 +1,138 @@
+package metricsmap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBudget_overflow(t *testing.T) {
+	// Two combinations, and the overflow
+	budget := &Budget{Limit: 3, Names: []string{"project_id", "name"}, Keep: []int{0}}
+	sum := MetricMapSumValues[float64]{KeepUntil: time.Hour, Budget: budget}
+	latest := MetricMapKeepFirst[float64]{KeepUntil: time.Hour, Budget: budget}
+	now := time.Now()
+
+	sum.Add(nil, 1.0, "p", "a")
+	latest.AddAt(nil, 1.0, now, "p", "a")
+	sum.Add(nil, 1.0, "p", "b")
+	// Over the limit
+	sum.Add(nil, 1.0, "p", "c")
+	sum.Add(nil, 1.0, "p", "d")
+	latest.AddAt(nil, 5.0, now, "p", "d")
+	// Known combinations are still accepted
+	sum.Add(nil, 1.0, "p", "a")
+
+	overflow := Key{nil, StringSliceHash([]string{"p", Overflow})}
+	if v, err := sum.Get(overflow); err != nil || v.Value != 2.0 || !reflect.DeepEqual(v.Labels, []string{"p", Overflow}) {
+		t.Errorf("MetricMapSumValues overflow = %v, %v, want 2.0", v, err)
+	}
+	if v, err := latest.Get(overflow); err != nil || v.Value != 5.0 {
+		t.Errorf("MetricMapKeepFirst overflow = %v, %v, want 5.0", v, err)
+	}
+	if v, _ := sum.Get(Key{nil, StringSliceHash([]string{"p", "a"})}); v.Value != 2.0 {
+		t.Errorf("MetricMapSumValues known combination = %v, want 2.0", v.Value)
+	}
+	if got := budget.Len(); got != budget.Limit {
+		t.Errorf("Budget.Len() = %v, want the limit %v", got, budget.Limit)
+	}
+	if got := budget.TakeDropped(); got != 2 {
+		t.Errorf("Budget.TakeDropped() = %v, want 2", got)
+	}
+	if got := budget.TakeDropped(); got != 0 {
+		t.Errorf("Budget.TakeDropped() = %v after being taken, want 0", got)
+	}
+	if got := budget.WorstLabels(1); !reflect.DeepEqual(got, []string{"name (3)"}) {
+		t.Errorf("Budget.WorstLabels() = %v, want [name (3)]", got)
+	}
+}
+
+func TestBudget_release(t *testing.T) {
+	budget := &Budget{Limit: 2}
+	sum := MetricMapSumValues[float64]{KeepUntil: time.Hour, Budget: budget}
+	latest := MetricMapKeepFirst[float64]{KeepUntil: time.Hour, Budget: budget}
+
+	sum.Add(nil, 1.0, "a")
+	latest.Add(nil, 1.0, "a")
+	sum.metrics[Key{nil, StringSliceHash([]string{"a"})}] = Value[float64]{1.0, []string{"a"}, time.Now().Add(-2 * time.Hour), time.Time{}, nil}
+	sum.Sweep(time.Now())
+	if got := budget.Len(); got != 1 {
+		t.Fatalf("Budget.Len() = %v, want 1 while a map still uses the combination", got)
+	}
+	latest.metrics[Key{nil, StringSliceHash([]string{"a"})}] = Value[float64]{1.0, []string{"a"}, time.Now().Add(-2 * time.Hour), time.Time{}, nil}
+	latest.Sweep(time.Now())
+	if got := budget.Len(); got != 0 {
+		t.Fatalf("Budget.Len() = %v, want 0 once the maps freed the combination", got)
+	}
+
+	sum.Add(nil, 1.0, "b")
+	if _, err := sum.Get(Key{nil, StringSliceHash([]string{"b"})}); err != nil {
+		t.Errorf("Budget should accept a new combination once the old one is freed : %v", err)
+	}
+}
+
+func TestBudget_combinations(t *testing.T) {
+	budget := &Budget{Limit: 3, Names: []string{"project_id", "name"}, Keep: []int{0}}
+	latest := MetricMapKeepFirst[float64]{KeepUntil: time.Hour, Budget: budget}
+
+	// The states of a test are a single combination
+	for _, state := range []string{"PASSED", "FAILED"} {
+		latest.Add(nil, 1.0, "p", "a", state)
+	}
+	latest.Add(nil, 1.0, "p", "a")
+	// Values without the name, eg a histogram, are another one
+	budget.acquire([]string{"project_id"}, budget.admit([]string{"project_id"}, []string{"p"}))
+	if got := budget.Len(); got != 2 {
+		t.Fatalf("Budget.Len() = %v, want 2", got)
+	}
+
+	// The state is kept in the overflow
+	latest.Add(nil, 1.0, "p", "b", "PASSED")
+	latest.Add(nil, 1.0, "p", "c", "FAILED")
+	for _, labels := range [][]string{{"p", Overflow, "PASSED"}, {"p", Overflow, "FAILED"}} {
+		if _, err := latest.Get(Key{nil, StringSliceHash(labels)}); err != nil {
+			t.Errorf("MetricMapKeepFirst should hold %v : %v", labels, err)
+		}
+	}
+	// The overflows of values with other labels are the same combination
+	folded := budget.admit([]string{"name", "project_id"}, []string{"d", "p"})
+	if !reflect.DeepEqual(folded, []string{Overflow, "p"}) {
+		t.Errorf("Budget.admit() = %v, want the labels folded in their order", folded)
+	}
+	budget.acquire([]string{"name", "project_id"}, folded)
+	if got := budget.Len(); got != budget.Limit {
+		t.Errorf("Budget.Len() = %v, want the limit %v", got, budget.Limit)
+	}
+}
+
+func TestBudget_overflowWithOtherLabels(t *testing.T) {
+	budget := &Budget{Limit: 2, Names: []string{"project_id", "name", "git_branch"}, Keep: []int{0}}
+	withoutName := []string{"project_id", "git_branch"}
+
+	budget.acquire(nil, budget.admit(nil, []string{"p", "a", "main"}))
+	budget.acquire(withoutName, budget.admit(withoutName, []string{"p", "main"}))
+	budget.acquire(nil, budget.admit(nil, []string{"p", "b", "main"}))
+	if got := budget.Len(); got != budget.Limit {
+		t.Errorf("Budget.Len() = %v, want the limit %v", got, budget.Limit)
+	}
+}
+
+func TestBudget_overflowPerProject(t *testing.T) {
+	// Two projects, with a combination and an overflow each
+	budget := &Budget{Limit: 4, Names: []string{"project_id", "name"}, Keep: []int{0}}
+	sum := MetricMapSumValues[float64]{KeepUntil: time.Hour, Budget: budget}
+
+	for _, name := range []string{"a", "b", "c"} {
+		for _, project := range []string{"p1", "p2"} {
+			sum.Add(nil, 1.0, project, name)
+		}
+	}
+	if got := budget.Len(); got != budget.Limit {
+		t.Errorf("Budget.Len() = %v, want the limit %v with the overflows", got, budget.Limit)
+	}
+	for _, project := range []string{"p1", "p2"} {
+		if v, err := sum.Get(Key{nil, StringSliceHash([]string{project, Overflow})}); err != nil || v.Value != 2.0 {
+			t.Errorf("overflow of %v = %v, %v, want 2.0", project, v, err)
+		}
+	}
+}
@@ -1,17 +1,36 @@
 package metricsmap
 
-import "fmt"
+import (
+	"encoding/binary"
 
-type labelsHash string
+	"github.com/cespare/xxhash/v2"
+)
 
-func StringSliceHash(s []string) labelsHash {
-
-	toHash := ""
-	separator := "___"
+type labelsHash uint64
 
+// StringSliceHash hashes the label values. Each value is prefixed with its length, so that two different label sets
+// never hash the same bytes, eg ["a___b"] and ["a", "b"]. The maps still compare the labels of a key, in case two
+// label sets end up with the same 64 bits hash.
+func StringSliceHash(s []string) labelsHash {
+	var d xxhash.Digest
+	d.Reset()
+	var length [binary.MaxVarintLen64]byte
 	for _, i := range s {
-		toHash = fmt.Sprintf("%v%v%v", i, separator, toHash)
+		n := binary.PutUvarint(length[:], uint64(len(i)))
+		d.Write(length[:n])
+		d.WriteString(i)
 	}
-	return labelsHash(toHash)
+	return labelsHash(d.Sum64())
+}
 
+func sameLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
@@ -0,0 +1,105 @@
+package metricsmap
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStringSliceHash_collisions(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []string
+	}{
+		{"Separator in a value", []string{"a___b"}, []string{"a", "b"}},
+		{"Separator at the end of a value", []string{"a___", "b"}, []string{"a", "___b"}},
+		{"Value moved to the next label", []string{"ab", "c"}, []string{"a", "bc"}},
+		{"Empty label", []string{"a"}, []string{"a", ""}},
+		{"Empty labels", []string{""}, []string{"", ""}},
+		{"Length looking like a value", []string{"\x01a"}, []string{"a"}},
+		{"Swapped values", []string{"a", "b"}, []string{"b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if StringSliceHash(tt.a) == StringSliceHash(tt.b) {
+				t.Errorf("StringSliceHash(%q) == StringSliceHash(%q)", tt.a, tt.b)
+			}
+		})
+	}
+}
+
+func TestStringSliceHash_stable(t *testing.T) {
+	if StringSliceHash([]string{"a", "b"}) != StringSliceHash([]string{"a", "b"}) {
+		t.Errorf("StringSliceHash() should be the same for the same labels")
+	}
+}
+
+func TestMetricMap_hashCollision(t *testing.T) {
+	labels := []string{"a"}
+	key := Key{nil, StringSliceHash(labels)}
+	// Another label set, stored under the same hash
+	sum := MetricMapSumValues[float64]{metrics: map[Key]Value[float64]{key: {1.0, []string{"b"}, time.Now(), time.Time{}, nil}}}
+	latest := MetricMapKeepFirst[float64]{metrics: map[Key]Value[float64]{key: {1.0, []string{"b"}, time.Now(), time.Time{}, nil}}}
+
+	sum.Add(nil, 2.0, labels...)
+	latest.AddAt(nil, 2.0, time.Now(), labels...)
+	for name, m := range map[string]MetricMap[float64]{"MetricMapSumValues": &sum, "MetricMapKeepFirst": &latest} {
+		if v, _ := m.Get(key); v.Value != 1.0 || !sameLabels(v.Labels, []string{"b"}) {
+			t.Errorf("%v mixed the values of colliding labels : %v", name, v)
+		}
+	}
+}
+
+// legacyStringSliceHash is the previous implementation, kept to compare the benchmarks.
+func legacyStringSliceHash(s []string) string {
+	toHash := ""
+	separator := "___"
+	for _, i := range s {
+		toHash = fmt.Sprintf("%v%v%v", i, separator, toHash)
+	}
+	return toHash
+}
+
+// testLabels returns the labels of n tests, like the ones of a run.
+func testLabels(n int) [][]string {
+	res := make([][]string, n)
+	for i := range res {
+		res[i] = []string{"7s5okt", "shop", "1", "github", "linux_20.04", "chrome_110", fmt.Sprintf("cypress/e2e/spec%v.cy.js", i/20), fmt.Sprintf("checkout works with the cart number %v", i), "main", "e2e"}
+	}
+	return res
+}
+
+func BenchmarkStringSliceHash(b *testing.B) {
+	labels := testLabels(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, l := range labels {
+			StringSliceHash(l)
+		}
+	}
+}
+
+func BenchmarkLegacyStringSliceHash(b *testing.B) {
+	labels := testLabels(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, l := range labels {
+			legacyStringSliceHash(l)
+		}
+	}
+}
+
+// BenchmarkMetricMapSumValues_Add processes a run of 10k tests
+func BenchmarkMetricMapSumValues_Add(b *testing.B) {
+	labels := testLabels(10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m := MetricMapSumValues[float64]{KeepUntil: time.Hour}
+		for _, l := range labels {
+			m.Add(nil, 1.0, l...)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package metricsmap
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
+)
+
+// For Histogram value. The buckets are kept by a vector of the prometheus client, the map tracks its label sets to
+// delete them from the vector once they expire or are evicted.
+type MetricMapHistogram struct {
+	Vec *prometheus.HistogramVec
+	// Names of the labels of the vector, in order. Required by a Budget of a family with other labels.
+	Names []string
+	// Label sets of the vector. The value isn't used.
+	metrics map[Key]Value[float64]
+	// Label sets still in the vector, deleted from it along with the entries
+	series    map[Key][]string
+	KeepUntil time.Duration
+	// Maximum number of entries, the least recently updated one is evicted to make room for a new one. Unlimited if 0.
+	MaxEntries int
+	// Limits the label combinations, shared with the other maps of the family. Unlimited if nil.
+	Budget *Budget
+	// Called for each evicted entry, with the reason of the eviction
+	OnEvict func(reason string)
+	// Time of the updates, the system clock if nil
+	Clock   clock.Clock
+	recency recency
+}
+
+// With returns the histogram of the labels to observe a value, the one of the overflow if they don't fit in the
+// budget.
+func (m *MetricMapHistogram) With(labels ...string) prometheus.Observer {
+	if m.metrics == nil {
+		m.metrics = map[Key]Value[float64]{}
+		m.series = map[Key][]string{}
+	}
+
+	labels = m.Budget.admit(m.Names, labels)
+	key := Key{
+		nil,
+		StringSliceHash(labels),
+	}
+	if currentValue, ok := m.metrics[key]; ok {
+		if !sameLabels(currentValue.Labels, labels) {
+			hashCollision(nil, currentValue.Labels, labels)
+			return prometheus.ObserverFunc(func(float64) {})
+		}
+	} else {
+		evictLeastRecent(m.metrics, &m.recency, m.MaxEntries, m.Budget, m.Names, m.OnEvict)
+		m.forgetEvicted()
+		m.Budget.acquire(m.Names, labels)
+		m.series[key] = labels
+	}
+	m.metrics[key] = Value[float64]{0, labels, clock.OrReal(m.Clock).Now(), time.Time{}, nil}
+	m.recency.touch(key)
+	return m.Vec.WithLabelValues(labels...)
+}
+
+// Len returns the number of label sets of the vector.
+func (m MetricMapHistogram) Len() int {
+	return len(m.metrics)
+}
+
+// Sweep removes the label sets that weren't observed for KeepUntil, and returns their number.
+func (m *MetricMapHistogram) Sweep(now time.Time) int {
+	evicted := freeOldItems(m.metrics, &m.recency, m.KeepUntil, m.Budget, m.Names, m.OnEvict, now)
+	m.forgetEvicted()
+	return evicted
+}
+
+// forgetEvicted deletes the label sets of the entries that have been evicted from the vector.
+func (m *MetricMapHistogram) forgetEvicted() {
+	if len(m.series) == len(m.metrics) {
+		return
+	}
+	for k, labels := range m.series {
+		if _, ok := m.metrics[k]; !ok {
+			m.Vec.DeleteLabelValues(labels...)
+			delete(m.series, k)
+		}
+	}
+}
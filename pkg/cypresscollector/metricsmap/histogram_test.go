@@ -0,0 +1,68 @@
+package metricsmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
+)
+
+func newTestHistogramVec() *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration_seconds", Help: "Duration"}, []string{"git_branch"})
+}
+
+func TestMetricMapHistogram_Sweep(t *testing.T) {
+	c := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	m := MetricMapHistogram{Vec: newTestHistogramVec(), KeepUntil: time.Hour, Clock: c}
+
+	m.With("main").Observe(1)
+	m.With("feature").Observe(1)
+	c.Advance(45 * time.Minute)
+	m.With("main").Observe(2)
+	c.Advance(45 * time.Minute)
+
+	if got := m.Sweep(c.Now()); got != 1 {
+		t.Errorf("Sweep() = %v, want 1", got)
+	}
+	if got := testutil.CollectAndCount(m.Vec); got != 1 || m.Len() != 1 {
+		t.Errorf("the vector has %v series and the map %v entries after Sweep(), want 1", got, m.Len())
+	}
+}
+
+func TestMetricMapHistogram_MaxEntries(t *testing.T) {
+	evictions := 0
+	m := MetricMapHistogram{Vec: newTestHistogramVec(), MaxEntries: 2, OnEvict: func(string) { evictions++ }}
+
+	for _, branch := range []string{"a", "b", "a", "c"} {
+		m.With(branch).Observe(1)
+	}
+	if got := testutil.CollectAndCount(m.Vec); got != 2 || evictions != 1 {
+		t.Errorf("the vector has %v series after %v evictions, want 2 after 1", got, evictions)
+	}
+}
+
+func TestMetricMapHistogram_Budget(t *testing.T) {
+	budget := &Budget{Limit: 2, Names: []string{"project_id", "name", "git_branch"}, Keep: []int{0}}
+	latest := MetricMapKeepFirst[float64]{Budget: budget}
+	m := MetricMapHistogram{
+		Vec:    prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "duration_seconds", Help: "Duration"}, []string{"git_branch", "project_id"}),
+		Names:  []string{"git_branch", "project_id"},
+		Budget: budget,
+	}
+
+	latest.Add(nil, 1.0, "p", "test", "main")
+	m.With("main", "p").Observe(1)
+	m.With("feature", "p").Observe(1)
+	if got := budget.Len(); got != budget.Limit {
+		t.Errorf("Budget.Len() = %v, want the limit %v", got, budget.Limit)
+	}
+	if got := testutil.CollectAndCount(m.Vec); got != 1 {
+		t.Errorf("the vector has %v series, want 1 for the overflow", got)
+	}
+	m.Vec.DeleteLabelValues(Overflow, "p")
+	if got := testutil.CollectAndCount(m.Vec); got != 0 {
+		t.Errorf("the series of the vector should be the overflow of the project, got %v series left", got)
+	}
+}
@@ -1,125 +1,310 @@
 package metricsmap
 
 import (
+	"container/list"
 	"fmt"
-	"reflect"
+	"sort"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/converter"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
 	"github.com/sirupsen/logrus"
 )
 
+// Number is the type of the values stored in a map.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64
+}
+
 type Key struct {
 	Prom *prometheus.Desc
 	Hash labelsHash
 }
 
-type Value struct {
-	Value      float64
+type Value[N Number] struct {
+	Value      N
 	Labels     []string
 	updated_at time.Time
+	// Time at which the value has been observed, if known. Zero otherwise.
+	Timestamp time.Time
+	// Latest exemplar of the value, if any
+	Exemplar *prometheus.Exemplar
 }
 
 func keyNotFoundError(k Key) error {
 	return fmt.Errorf("Cannot find the key %v", k)
 }
 
+// hashCollision reports two label sets with the same hash. The new value is dropped, rather than being mixed with
+// the value of other labels.
+func hashCollision(k *prometheus.Desc, current, labels []string) {
+	logrus.Errorf("Labels %v and %v of metric %v have the same hash, skipping the value of %v", current, labels, k, labels)
+}
+
 // MetricMap is an interface to allow to store metrics, and then
 //  get them passed to prometheus.
 //  The idea behind is that, for some of these, we want to keep only the first value,
 //  whereas for other the sum, or ever something else in the future.
-type MetricMap interface {
+type MetricMap[N Number] interface {
 	// Add to the map
-	Add(*prometheus.Desc, interface{}, ...string)
+	Add(*prometheus.Desc, N, ...string)
 
 	// Get value from the map
-	Get(Key) (*Value, error)
+	Get(Key) (*Value[N], error)
 	// Map returns the map
-	Map() map[Key]Value
+	Map() map[Key]Value[N]
 	// Free old items, to not keep metrics from branches or web browser that hasn't been found for some time
-	FreeOldItems()
+	Sweep(now time.Time) int
 }
 
+// Reasons for evicting an entry from a map
+const (
+	// Not updated for KeepUntil
+	EvictionExpired = "expired"
+	// Least recently updated entry, removed to make room for a new one
+	EvictionLRU = "lru"
+)
+
 // For Gauge value
-type MetricMapKeepFirst struct {
-	metrics   map[Key]Value
+type MetricMapKeepFirst[N Number] struct {
+	metrics   map[Key]Value[N]
 	KeepUntil time.Duration
+	// Maximum number of entries, the least recently updated one is evicted to make room for a new one. Unlimited if 0.
+	MaxEntries int
+	// Limits the label combinations, shared with the other maps of the family. Unlimited if nil.
+	Budget *Budget
+	// Called for each evicted entry, with the reason of the eviction
+	OnEvict func(reason string)
+	// Time of the updates, the system clock if nil
+	Clock clock.Clock
+	// Combines the values of a metric observed at the same timestamp. Metrics without one keep the last value.
+	Ties    map[*prometheus.Desc]Tie[N]
+	recency recency
+}
+
+// Tie combines two values of an entry observed at the same timestamp : they come from the same run, and only differ
+// by labels that have been dropped, eg the same test ran on several browsers.
+type Tie[N Number] func(current, value N) N
+
+// SumTie adds the values together, eg the durations of the tests of a spec file once the test name is dropped.
+func SumTie[N Number](current, value N) N {
+	return current + value
+}
+
+// MaxTie keeps the highest value, eg a test state is 1 if it's in this state on any of the browsers.
+func MaxTie[N Number](current, value N) N {
+	if value > current {
+		return value
+	}
+	return current
+}
+
+func (m *MetricMapKeepFirst[N]) Add(k *prometheus.Desc, value N, labels ...string) {
+	m.AddAt(k, value, time.Time{}, labels...)
 }
 
-func (m *MetricMapKeepFirst) Add(k *prometheus.Desc, value interface{}, labels ...string) {
+// AddAt adds a value observed at the given timestamp. A value older than the one already in the map is ignored,
+// so that the map always holds the most recent observation.
+// Values observed at the same timestamp are combined by the Tie of the metric, if any.
+func (m *MetricMapKeepFirst[N]) AddAt(k *prometheus.Desc, value N, timestamp time.Time, labels ...string) {
 	if m.metrics == nil {
-		m.metrics = map[Key]Value{}
+		m.metrics = map[Key]Value[N]{}
 	}
 
+	labels = m.Budget.admit(nil, labels)
 	labelsHash := StringSliceHash(labels)
-	v, err := converter.ConvertValueForPrometheus(value)
-	if err != nil {
-		logrus.Errorf("Can't convert metric %v type %v to float64 - original error: %v", k.String(), reflect.TypeOf(value), err)
-	}
 	key := Key{
 		k,
 		labelsHash,
 	}
-	m.metrics[key] = Value{v, labels, time.Now()}
+	if currentValue, ok := m.metrics[key]; ok {
+		if !sameLabels(currentValue.Labels, labels) {
+			hashCollision(k, currentValue.Labels, labels)
+			return
+		}
+		if timestamp.Before(currentValue.Timestamp) {
+			return
+		}
+		if tie, ok := m.Ties[k]; ok && !timestamp.IsZero() && timestamp.Equal(currentValue.Timestamp) {
+			value = tie(currentValue.Value, value)
+		}
+	} else {
+		evictLeastRecent(m.metrics, &m.recency, m.MaxEntries, m.Budget, nil, m.OnEvict)
+		m.Budget.acquire(nil, labels)
+	}
+	m.metrics[key] = Value[N]{value, labels, clock.OrReal(m.Clock).Now(), timestamp, nil}
+	m.recency.touch(key)
 }
 
-func (m MetricMapKeepFirst) Get(k Key) (*Value, error) {
+func (m MetricMapKeepFirst[N]) Get(k Key) (*Value[N], error) {
 	if v, ok := m.metrics[k]; ok {
 		return &v, nil
 	}
 	return nil, keyNotFoundError(k)
 }
 
-func (m MetricMapKeepFirst) Map() map[Key]Value {
-	freeOldItems(m.metrics, m.KeepUntil)
+// Map returns the map. Expired entries are only removed by Sweep.
+func (m MetricMapKeepFirst[N]) Map() map[Key]Value[N] {
 	return m.metrics
 }
 
+// Sweep removes the entries that weren't updated for KeepUntil, and returns their number.
+func (m *MetricMapKeepFirst[N]) Sweep(now time.Time) int {
+	return freeOldItems(m.metrics, &m.recency, m.KeepUntil, m.Budget, nil, m.OnEvict, now)
+}
+
 // For Summary value
-type MetricMapSumValues struct {
-	metrics   map[Key]Value
+type MetricMapSumValues[N Number] struct {
+	metrics   map[Key]Value[N]
 	KeepUntil time.Duration
+	// Maximum number of entries, the least recently updated one is evicted to make room for a new one. Unlimited if 0.
+	MaxEntries int
+	// Limits the label combinations, shared with the other maps of the family. Unlimited if nil.
+	Budget *Budget
+	// Called for each evicted entry, with the reason of the eviction
+	OnEvict func(reason string)
+	// Time of the updates, the system clock if nil
+	Clock   clock.Clock
+	recency recency
+}
+
+func (m *MetricMapSumValues[N]) Add(k *prometheus.Desc, value N, labels ...string) {
+	m.AddWithExemplar(k, value, nil, labels...)
 }
 
-func (m *MetricMapSumValues) Add(k *prometheus.Desc, value interface{}, labels ...string) {
+// AddWithExemplar adds the value to the sum. If exemplar isn't nil, it replaces the exemplar of the sum, otherwise
+// the previous one is kept.
+func (m *MetricMapSumValues[N]) AddWithExemplar(k *prometheus.Desc, value N, exemplar *prometheus.Exemplar, labels ...string) {
 	if m.metrics == nil {
-		m.metrics = map[Key]Value{}
+		m.metrics = map[Key]Value[N]{}
 	}
 
+	labels = m.Budget.admit(nil, labels)
 	labelsHash := StringSliceHash(labels)
-	v, err := converter.ConvertValueForPrometheus(value)
-	if err != nil {
-		logrus.Errorf("Can't convert metric %v type %v to float64 - original error: %v", k.String(), reflect.TypeOf(value), err)
-	}
 	key := Key{
 		k,
 		labelsHash,
 	}
 	if currentValue, ok := m.metrics[key]; ok {
-		m.metrics[key] = Value{currentValue.Value + v, labels, time.Now()}
+		if !sameLabels(currentValue.Labels, labels) {
+			hashCollision(k, currentValue.Labels, labels)
+			return
+		}
+		if exemplar == nil {
+			exemplar = currentValue.Exemplar
+		}
+		m.metrics[key] = Value[N]{currentValue.Value + value, labels, clock.OrReal(m.Clock).Now(), time.Time{}, exemplar}
+		m.recency.touch(key)
 		return
 	}
-	m.metrics[key] = Value{v, labels, time.Now()}
+	evictLeastRecent(m.metrics, &m.recency, m.MaxEntries, m.Budget, nil, m.OnEvict)
+	m.Budget.acquire(nil, labels)
+	m.metrics[key] = Value[N]{value, labels, clock.OrReal(m.Clock).Now(), time.Time{}, exemplar}
+	m.recency.touch(key)
 }
 
-func (m MetricMapSumValues) Get(k Key) (*Value, error) {
+func (m MetricMapSumValues[N]) Get(k Key) (*Value[N], error) {
 	if v, ok := m.metrics[k]; ok {
 		return &v, nil
 	}
 	return nil, keyNotFoundError(k)
 }
 
-func (m MetricMapSumValues) Map() map[Key]Value {
-	freeOldItems(m.metrics, m.KeepUntil)
+// Map returns the map. Expired entries are only removed by Sweep.
+func (m MetricMapSumValues[N]) Map() map[Key]Value[N] {
 	return m.metrics
 }
 
-func freeOldItems(m map[Key]Value, keepUntil time.Duration) {
+// Sweep removes the entries that weren't updated for KeepUntil, and returns their number.
+func (m *MetricMapSumValues[N]) Sweep(now time.Time) int {
+	return freeOldItems(m.metrics, &m.recency, m.KeepUntil, m.Budget, nil, m.OnEvict, now)
+}
+
+func freeOldItems[N Number](m map[Key]Value[N], r *recency, keepUntil time.Duration, budget *Budget, names []string, onEvict func(string), now time.Time) int {
+	evicted := 0
 	for k, v := range m {
-		if v.updated_at.Add(keepUntil).Before(time.Now()) {
+		if v.updated_at.Add(keepUntil).Before(now) {
 			logrus.Debugf("Removing entry from map %v : %v\n", k, v)
 			delete(m, k)
+			r.remove(k)
+			budget.release(names, v.Labels)
+			if onEvict != nil {
+				onEvict(EvictionExpired)
+			}
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// evictLeastRecent removes the least recently updated entries, until there's room for a new one.
+func evictLeastRecent[N Number](m map[Key]Value[N], r *recency, maxEntries int, budget *Budget, names []string, onEvict func(string)) {
+	if maxEntries <= 0 {
+		return
+	}
+	indexRecency(r, m)
+	for len(m) >= maxEntries {
+		k, ok := r.oldest()
+		if !ok {
+			return
 		}
+		logrus.Debugf("Evicting least recently updated entry %v, the map is full\n", k)
+		budget.release(names, m[k].Labels)
+		delete(m, k)
+		r.remove(k)
+		if onEvict != nil {
+			onEvict(EvictionLRU)
+		}
+	}
+}
+
+// recency orders the keys of a map from the least to the most recently updated.
+type recency struct {
+	order    *list.List
+	elements map[Key]*list.Element
+}
+
+// indexRecency builds the order from the update times of the entries, if the map was filled without it.
+func indexRecency[N Number](r *recency, m map[Key]Value[N]) {
+	if r.elements != nil && len(r.elements) == len(m) {
+		return
+	}
+	keys := make([]Key, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return m[keys[i]].updated_at.Before(m[keys[j]].updated_at)
+	})
+	r.order = list.New()
+	r.elements = map[Key]*list.Element{}
+	for _, k := range keys {
+		r.elements[k] = r.order.PushBack(k)
+	}
+}
+
+func (r *recency) touch(k Key) {
+	if r.elements == nil {
+		r.order = list.New()
+		r.elements = map[Key]*list.Element{}
+	}
+	if e, ok := r.elements[k]; ok {
+		r.order.MoveToBack(e)
+		return
+	}
+	r.elements[k] = r.order.PushBack(k)
+}
+
+func (r *recency) remove(k Key) {
+	if e, ok := r.elements[k]; ok {
+		r.order.Remove(e)
+		delete(r.elements, k)
+	}
+}
+
+func (r *recency) oldest() (Key, bool) {
+	if r.order == nil || r.order.Len() == 0 {
+		return Key{}, false
 	}
+	return r.order.Front().Value.(Key), true
 }
@@ -4,43 +4,52 @@ import (
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
 )
 
-func TestMetricMapSumValues_Map(t *testing.T) {
+func TestMetricMapSumValues_Sweep(t *testing.T) {
 	type fields struct {
-		metrics   map[Key]Value
+		metrics   map[Key]Value[float64]
 		keepUntil time.Duration
 	}
 	now := time.Now()
 	tests := []struct {
 		name   string
 		fields fields
-		want   map[Key]Value
+		want   map[Key]Value[float64]
 	}{
 		{
 			name: "test old items",
 			fields: fields{
-				metrics: map[Key]Value{
+				metrics: map[Key]Value[float64]{
 					// Item to keep
-					Key{nil, "future"}: Value{
+					Key{nil, 1}: Value[float64]{
 						0.0,
 						[]string{},
 						now,
+						time.Time{},
+						nil,
 					},
 					// Too old item that should be removed
-					Key{nil, "old"}: Value{
+					Key{nil, 2}: Value[float64]{
 						0.0,
 						[]string{},
 						now.Add(-time.Hour * 10),
+						time.Time{},
+						nil,
 					},
 				},
 				keepUntil: time.Duration(2 * time.Hour),
 			},
-			want: map[Key]Value{
-				Key{nil, "future"}: Value{
+			want: map[Key]Value[float64]{
+				Key{nil, 1}: Value[float64]{
 					0.0,
 					[]string{},
 					now,
+					time.Time{},
+					nil,
 				},
 			},
 		},
@@ -48,13 +57,181 @@ func TestMetricMapSumValues_Map(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m := MetricMapSumValues{
+			m := MetricMapSumValues[float64]{
 				metrics:   tt.fields.metrics,
 				KeepUntil: tt.fields.keepUntil,
 			}
+			if got := m.Sweep(now); got != 1 {
+				t.Errorf("MetricMapSumValues.Sweep() = %v, want 1", got)
+			}
 			if got := m.Map(); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("MetricMapSumValues.Map() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestMetricMapKeepFirst_AddAt(t *testing.T) {
+	now := time.Now()
+	m := MetricMapKeepFirst[float64]{KeepUntil: time.Hour}
+
+	m.AddAt(nil, 1.0, now, "a")
+	m.AddAt(nil, 2.0, now.Add(-time.Minute), "a")
+	if v, _ := m.Get(Key{nil, StringSliceHash([]string{"a"})}); v.Value != 1.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the most recent value 1.0", v.Value)
+	}
+
+	m.AddAt(nil, 3.0, now.Add(time.Minute), "a")
+	if v, _ := m.Get(Key{nil, StringSliceHash([]string{"a"})}); v.Value != 3.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the most recent value 3.0", v.Value)
+	}
+}
+
+func TestMetricMapKeepFirst_AddAtSameTime(t *testing.T) {
+	now := time.Now()
+	sum := prometheus.NewDesc("sum", "Summed ties", nil, nil)
+	max := prometheus.NewDesc("max", "Highest tie", nil, nil)
+	last := prometheus.NewDesc("last", "No tie", nil, nil)
+	m := MetricMapKeepFirst[float64]{KeepUntil: time.Hour, Ties: map[*prometheus.Desc]Tie[float64]{
+		sum: SumTie[float64],
+		max: MaxTie[float64],
+	}}
+	value := func(k *prometheus.Desc, labels ...string) float64 {
+		v, _ := m.Get(Key{k, StringSliceHash(labels)})
+		return v.Value
+	}
+
+	// Same run, labels that would tell them apart have been dropped
+	for _, k := range []*prometheus.Desc{sum, max, last} {
+		m.AddAt(k, 1.0, now, "a")
+		m.AddAt(k, 2.0, now, "a")
+		m.AddAt(k, 1.0, now, "a")
+	}
+	if got := value(sum, "a"); got != 4.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the sum of the values 4.0", got)
+	}
+	if got := value(max, "a"); got != 2.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the highest value 2.0", got)
+	}
+	if got := value(last, "a"); got != 1.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the last value 1.0 without tie", got)
+	}
+
+	m.AddAt(sum, 4.0, now.Add(time.Minute), "a")
+	if got := value(sum, "a"); got != 4.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the most recent value 4.0", got)
+	}
+
+	// Without timestamp, the last value is kept
+	m.AddAt(sum, 1.0, time.Time{}, "b")
+	m.AddAt(sum, 2.0, time.Time{}, "b")
+	if got := value(sum, "b"); got != 2.0 {
+		t.Errorf("MetricMapKeepFirst.AddAt() kept %v, want the last value 2.0", got)
+	}
+}
+
+func TestMetricMap_MaxEntries(t *testing.T) {
+	evictions := map[string]int{}
+	onEvict := func(reason string) { evictions[reason]++ }
+	sum := MetricMapSumValues[float64]{KeepUntil: time.Hour, MaxEntries: 2, OnEvict: onEvict}
+	latest := MetricMapKeepFirst[float64]{KeepUntil: time.Hour, MaxEntries: 2, OnEvict: onEvict}
+	now := time.Now()
+
+	sum.Add(nil, 1.0, "a")
+	sum.Add(nil, 1.0, "b")
+	// a becomes the most recently updated entry, b is evicted
+	sum.Add(nil, 1.0, "a")
+	sum.Add(nil, 1.0, "c")
+	latest.AddAt(nil, 1.0, now, "a")
+	latest.AddAt(nil, 1.0, now, "b")
+	latest.AddAt(nil, 1.0, now, "c")
+
+	for _, tt := range []struct {
+		name string
+		m    MetricMap[float64]
+		want []string
+	}{
+		{"MetricMapSumValues", &sum, []string{"a", "c"}},
+		{"MetricMapKeepFirst", &latest, []string{"b", "c"}},
+	} {
+		if got := len(tt.m.Map()); got != 2 {
+			t.Errorf("%v has %v entries, want 2", tt.name, got)
+		}
+		for _, l := range tt.want {
+			if _, err := tt.m.Get(Key{nil, StringSliceHash([]string{l})}); err != nil {
+				t.Errorf("%v should keep %v : %v", tt.name, l, err)
+			}
+		}
+	}
+	if evictions[EvictionLRU] != 2 {
+		t.Errorf("OnEvict(%v) called %v times, want 2", EvictionLRU, evictions[EvictionLRU])
+	}
+}
+
+func TestMetricMap_MaxEntriesWithoutIndex(t *testing.T) {
+	now := time.Now()
+	// Filled without going through Add, the order comes from the update times
+	m := MetricMapSumValues[float64]{
+		metrics: map[Key]Value[float64]{
+			{nil, 3}: {1.0, []string{"recent"}, now, time.Time{}, nil},
+			{nil, 2}: {1.0, []string{"old"}, now.Add(-time.Minute), time.Time{}, nil},
+		},
+		KeepUntil:  time.Hour,
+		MaxEntries: 2,
+	}
+	m.Add(nil, 1.0, "new")
+	if _, err := m.Get(Key{nil, 2}); err == nil {
+		t.Errorf("MetricMapSumValues.Add() should evict the least recently updated entry")
+	}
+	if _, err := m.Get(Key{nil, 3}); err != nil {
+		t.Errorf("MetricMapSumValues.Add() evicted the most recent entry : %v", err)
+	}
+}
+
+func TestMetricMap_SweepWithClock(t *testing.T) {
+	tests := []struct {
+		name   string
+		newMap func(c clock.Clock) MetricMap[float64]
+	}{
+		{"MetricMapSumValues", func(c clock.Clock) MetricMap[float64] {
+			return &MetricMapSumValues[float64]{KeepUntil: time.Hour, Clock: c}
+		}},
+		{"MetricMapKeepFirst", func(c clock.Clock) MetricMap[float64] {
+			return &MetricMapKeepFirst[float64]{KeepUntil: time.Hour, Clock: c}
+		}},
+		{"MetricMapAggregate", func(c clock.Clock) MetricMap[float64] {
+			return &MetricMapAggregate{KeepUntil: time.Hour, Clock: c, Aggregation: Max(0)}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+			m := tt.newMap(c)
+			m.Add(nil, 1.0, "old")
+			c.Advance(45 * time.Minute)
+			m.Add(nil, 1.0, "recent")
+
+			if evicted := m.Sweep(c.Now()); evicted != 0 {
+				t.Errorf("Sweep() removed %v entries before their expiration", evicted)
+			}
+			c.Advance(30 * time.Minute)
+			if evicted := m.Sweep(c.Now()); evicted != 1 {
+				t.Errorf("Sweep() removed %v entries, want the old one", evicted)
+			}
+			if _, err := m.Get(Key{nil, StringSliceHash([]string{"recent"})}); err != nil {
+				t.Errorf("Sweep() removed the recent entry : %v", err)
+			}
+		})
+	}
+}
+
+func TestMetricMapSumValues_int(t *testing.T) {
+	// Integer maps sum exactly, where a float64 would lose the small values
+	m := MetricMapSumValues[int64]{}
+	for _, v := range []int64{1 << 53, 1, 1} {
+		m.Add(nil, v, "a")
+	}
+	if v, _ := m.Get(Key{nil, StringSliceHash([]string{"a"})}); v.Value != 1<<53+2 {
+		t.Errorf("MetricMapSumValues.Add() = %v, want %v", v.Value, int64(1<<53+2))
+	}
+}
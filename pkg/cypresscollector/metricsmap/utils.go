@@ -2,8 +2,8 @@ package metricsmap
 
 import "github.com/prometheus/client_golang/prometheus"
 
-func MultipleAdd(ms ...MetricMap) func(*prometheus.Desc, interface{}, ...string) {
-	return func(k *prometheus.Desc, v interface{}, labels ...string) {
+func MultipleAdd[N Number](ms ...MetricMap[N]) func(*prometheus.Desc, N, ...string) {
+	return func(k *prometheus.Desc, v N, labels ...string) {
 		for _, m := range ms {
 			m.Add(k, v, labels...)
 		}
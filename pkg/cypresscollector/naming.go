@@ -0,0 +1,116 @@
+package cypresscollector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricNamesMode selects the naming schema of the exported metrics.
+// The current schema follows the Prometheus conventions ( base units, `_total` suffix for counters ),
+// whereas the legacy one is kept to give some time to migrate dashboards and alerts.
+type MetricNamesMode string
+
+const (
+	MetricNamesCurrent MetricNamesMode = "current"
+	MetricNamesLegacy  MetricNamesMode = "legacy"
+	MetricNamesBoth    MetricNamesMode = "both"
+)
+
+func ParseMetricNamesMode(mode string) (MetricNamesMode, error) {
+	switch m := MetricNamesMode(mode); m {
+	case MetricNamesCurrent, MetricNamesLegacy, MetricNamesBoth:
+		return m, nil
+	case "":
+		return MetricNamesCurrent, nil
+	}
+	return MetricNamesCurrent, fmt.Errorf("unknown metric names mode %q, expected one of current, legacy or both", mode)
+}
+
+// metricOutput is a metric actually sent to prometheus.
+type metricOutput struct {
+	name      string
+	desc      *prometheus.Desc
+	transform metricTransformer
+}
+
+// metricNames keeps track of the names under which a metric is exported.
+// Values are stored in the metric maps in their legacy unit ( ms, unix timestamp ) under a single
+// key, and are transformed when they're collected.
+type metricNames struct {
+	mode    MetricNamesMode
+	outputs map[*prometheus.Desc][]metricOutput
+	// Number of series sent by metric name since the last reset
+	series map[string]int
+}
+
+func newMetricNames(mode MetricNamesMode) *metricNames {
+	if mode == "" {
+		mode = MetricNamesCurrent
+	}
+	return &metricNames{
+		mode:    mode,
+		outputs: map[*prometheus.Desc][]metricOutput{},
+		series:  map[string]int{},
+	}
+}
+
+func (n *metricNames) current() bool {
+	return n.mode != MetricNamesLegacy
+}
+
+func (n *metricNames) legacy() bool {
+	return n.mode != MetricNamesCurrent
+}
+
+// desc returns the key to use in the metric maps for a metric.
+// An empty name means that the metric only exists in the legacy schema, and an empty legacy name that it's a new metric.
+// transform converts a stored value into the unit of the current schema.
+func (n *metricNames) desc(name, legacyName, help string, labels []string, transform metricTransformer) *prometheus.Desc {
+	if name == "" {
+		key := prometheus.NewDesc(legacyName, help, labels, prometheus.Labels{})
+		if n.legacy() {
+			n.outputs[key] = []metricOutput{{legacyName, key, noopTransformer}}
+		}
+		return key
+	}
+
+	key := prometheus.NewDesc(name, help, labels, prometheus.Labels{})
+	outputs := []metricOutput{}
+	if n.current() {
+		outputs = append(outputs, metricOutput{name, key, transform})
+	}
+	if n.legacy() && legacyName != "" {
+		if legacyName == name {
+			// Same name in both schemas, don't send it twice
+			if !n.current() {
+				outputs = append(outputs, metricOutput{name, key, noopTransformer})
+			}
+		} else {
+			outputs = append(outputs, metricOutput{legacyName, prometheus.NewDesc(legacyName, help, labels, prometheus.Labels{}), noopTransformer})
+		}
+	}
+	n.outputs[key] = outputs
+	return key
+}
+
+func (n *metricNames) describe(ch chan<- *prometheus.Desc) {
+	for _, outputs := range n.outputs {
+		for _, o := range outputs {
+			ch <- o.desc
+		}
+	}
+}
+
+// emit sends the value stored under key with all its names.
+func (n *metricNames) emit(ch chan<- prometheus.Metric, key *prometheus.Desc, valueType prometheus.ValueType, value float64, labels []string, timestamp time.Time, exemplar *prometheus.Exemplar) {
+	for _, o := range n.outputs[key] {
+		maybeMetric(ch, o.desc, valueType, value, o.transform, labels, timestamp, exemplar)
+		n.series[o.name]++
+	}
+}
+
+func (n *metricNames) resetSeries() {
+	n.series = map[string]int{}
+}
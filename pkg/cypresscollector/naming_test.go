@@ -0,0 +1,69 @@
+package cypresscollector
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricNames_emit(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      MetricNamesMode
+		wantNames []string
+		wantValue []float64
+	}{
+		{"Current names should be in seconds", MetricNamesCurrent, []string{"cypress_run_duration_seconds_last"}, []float64{1.5}},
+		{"Legacy names should be in ms", MetricNamesLegacy, []string{"cypress_run_duration_ms_last"}, []float64{1500}},
+		{"Both names should be sent", MetricNamesBoth, []string{"cypress_run_duration_ms_last", "cypress_run_duration_seconds_last"}, []float64{1500, 1.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := newMetricNames(tt.mode)
+			key := names.desc("cypress_run_duration_seconds_last", "cypress_run_duration_ms_last", "Duration", []string{}, msToSec)
+
+			ch := make(chan prometheus.Metric, 10)
+			names.emit(ch, key, prometheus.GaugeValue, 1500, []string{}, time.Time{}, nil)
+			close(ch)
+
+			type sample struct {
+				name  string
+				value float64
+			}
+			got := []sample{}
+			for m := range ch {
+				pb := dto.Metric{}
+				if err := m.Write(&pb); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, sample{m.Desc().String(), pb.GetGauge().GetValue()})
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i].name < got[j].name })
+
+			if len(got) != len(tt.wantNames) {
+				t.Fatalf("metricNames.emit() sent %v metrics, want %v", len(got), len(tt.wantNames))
+			}
+			for i := range got {
+				want := prometheus.NewDesc(tt.wantNames[i], "Duration", []string{}, prometheus.Labels{}).String()
+				if got[i].name != want || got[i].value != tt.wantValue[i] {
+					t.Errorf("metricNames.emit()[%v] = %v %v, want %v %v", i, got[i].name, got[i].value, want, tt.wantValue[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMetricNames_legacyOnly(t *testing.T) {
+	names := newMetricNames(MetricNamesCurrent)
+	key := names.desc("", "cypress_run_start_time_ms_sum", "Start time", []string{}, noopTransformer)
+
+	ch := make(chan prometheus.Metric, 10)
+	names.emit(ch, key, prometheus.CounterValue, 1500, []string{}, time.Time{}, nil)
+	close(ch)
+	if len(ch) != 0 {
+		t.Errorf("legacy only metric shouldn't be sent with the current names")
+	}
+}
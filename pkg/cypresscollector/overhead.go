@@ -0,0 +1,94 @@
+package cypresscollector
+
+import (
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+// From one second to a bit more than an hour
+var overheadBuckets = prometheus.ExponentialBuckets(1, 2, 13)
+
+// runOverhead is the time of a run that isn't spent running spec files.
+type runOverhead struct {
+	// From the creation of the run to the start of its first instance
+	setup    time.Duration
+	hasSetup bool
+	// Idle time between two consecutive instances on the same machine
+	gaps []time.Duration
+	// From the completion of the last instance to the end of the run
+	teardown    time.Duration
+	hasTeardown bool
+}
+
+// runCompletedAt returns the end of the run, falling back on its duration when the dashboard doesn't provide it.
+func runCompletedAt(run cypressclient.RunResult) time.Time {
+	if !run.CompletedAt.IsZero() || run.StartTime.IsZero() {
+		return run.CompletedAt
+	}
+	return run.StartTime.Add(time.Duration(run.TotalDuration) * time.Millisecond)
+}
+
+func computeRunOverhead(run cypressclient.RunResult) runOverhead {
+	res := runOverhead{}
+
+	byMachine := map[string][]cypressclient.TestResult{}
+	var firstStart, lastCompletion time.Time
+	for _, spec := range specInstances(run) {
+		test := spec.ctx.testResult
+		if test.Instance.StartedAt.IsZero() || test.Instance.CompletedAt.IsZero() {
+			continue
+		}
+		byMachine[test.Instance.MachineID] = append(byMachine[test.Instance.MachineID], test)
+		if firstStart.IsZero() || test.Instance.StartedAt.Before(firstStart) {
+			firstStart = test.Instance.StartedAt
+		}
+		if test.Instance.CompletedAt.After(lastCompletion) {
+			lastCompletion = test.Instance.CompletedAt
+		}
+	}
+	if firstStart.IsZero() {
+		return res
+	}
+
+	// Timestamps come from different machines, skip the values that make no sense because of clock skews
+	if !run.CreatedAt.IsZero() {
+		res.setup = firstStart.Sub(run.CreatedAt)
+		res.hasSetup = res.setup >= 0
+	}
+	if completedAt := runCompletedAt(run); !completedAt.IsZero() {
+		res.teardown = completedAt.Sub(lastCompletion)
+		res.hasTeardown = res.teardown >= 0
+	}
+
+	// Without machine ID, all the instances are in the same bucket, and overlapping instances are ignored below.
+	for _, tests := range byMachine {
+		sort.Slice(tests, func(i, j int) bool {
+			return tests[i].Instance.StartedAt.Before(tests[j].Instance.StartedAt)
+		})
+		for i := 1; i < len(tests); i++ {
+			gap := tests[i].Instance.StartedAt.Sub(tests[i-1].Instance.CompletedAt)
+			if gap >= 0 {
+				res.gaps = append(res.gaps, gap)
+			}
+		}
+	}
+	return res
+}
+
+func (c *CypressDashboardCollector) processOverhead(metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult) {
+	overhead := computeRunOverhead(run)
+	labels := evaluateLabels(c.overheadLabels, metrics, run)
+
+	if overhead.hasSetup {
+		c.runSetupDuration.With(labels...).Observe(overhead.setup.Seconds())
+	}
+	for _, gap := range overhead.gaps {
+		c.runInstanceGap.With(labels...).Observe(gap.Seconds())
+	}
+	if overhead.hasTeardown {
+		c.runTeardownDuration.With(labels...).Observe(overhead.teardown.Seconds())
+	}
+}
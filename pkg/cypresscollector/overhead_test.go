@@ -0,0 +1,83 @@
+package cypresscollector
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestComputeRunOverhead(t *testing.T) {
+	created := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return created.Add(time.Duration(seconds) * time.Second)
+	}
+	instance := func(id, machine string, start, end int) cypressclient.TestResult {
+		t := testOnMachine(id, machine, (end-start)*1000)
+		t.Instance.StartedAt = at(start)
+		t.Instance.CompletedAt = at(end)
+		return t
+	}
+
+	run := cypressclient.RunResult{
+		CreatedAt:   created,
+		StartTime:   at(5),
+		CompletedAt: at(100),
+	}
+	run.TestResults.Nodes = []cypressclient.TestResult{
+		instance("2", "a", 40, 60),
+		instance("1", "a", 30, 35),
+		instance("3", "b", 31, 90),
+	}
+
+	want := runOverhead{
+		setup:       30 * time.Second,
+		hasSetup:    true,
+		gaps:        []time.Duration{5 * time.Second},
+		teardown:    10 * time.Second,
+		hasTeardown: true,
+	}
+	if got := computeRunOverhead(run); !reflect.DeepEqual(got, want) {
+		t.Errorf("computeRunOverhead() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCypressDashboardCollector_processOverhead_retention(t *testing.T) {
+	c := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CollectorOptions{Clock: c, Retention: RetentionOptions{RunFamily: {KeepUntil: time.Hour}}}
+	collector, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := cypressclient.RunResult{CreatedAt: c.Now(), CompletedAt: c.Now().Add(time.Minute)}
+	for _, branch := range []string{"main", "feature"} {
+		run.Commit.Branch = branch
+		test := testOnMachine("1", "a", 1000)
+		test.Instance.StartedAt = c.Now().Add(10 * time.Second)
+		test.Instance.CompletedAt = c.Now().Add(50 * time.Second)
+		run.TestResults.Nodes = []cypressclient.TestResult{test}
+		collector.processOverhead(cypressclient.StatsFromCypressDashboard{}, run)
+	}
+	if got := testutil.CollectAndCount(collector.CypressRunSetupDuration); got != 2 {
+		t.Fatalf("cypress_run_setup_duration_seconds has %v series, want one by branch", got)
+	}
+	if got := collector.budgets[RunFamily].Len(); got != 2 {
+		t.Errorf("the run budget counts %v combinations, want one by branch", got)
+	}
+
+	c.Advance(2 * time.Hour)
+	collector.Sweep()
+	for _, vec := range []*prometheus.HistogramVec{collector.CypressRunSetupDuration, collector.CypressRunTeardownDuration} {
+		if got := testutil.CollectAndCount(vec); got != 0 {
+			t.Errorf("CypressDashboardCollector.Sweep() left %v series of an overhead histogram after the run retention", got)
+		}
+	}
+	if got := collector.budgets[RunFamily].Len(); got != 0 {
+		t.Errorf("the run budget counts %v combinations after the sweep, want 0", got)
+	}
+}
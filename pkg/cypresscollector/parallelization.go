@@ -0,0 +1,86 @@
+package cypresscollector
+
+import (
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+// parallelizationStats describes how well the spec files of a run have been spread across machines.
+// Instances are rebuilt from the test results, so a spec file without any test isn't accounted for.
+type parallelizationStats struct {
+	machines int
+	// Number of run instances without machine
+	unassigned int
+	// Sum of the duration of every run instance, in ms
+	instancesDuration int
+	// Busy time of the most and least loaded machines, in ms
+	longestMachine  int
+	shortestMachine int
+}
+
+func runParallelization(run cypressclient.RunResult) parallelizationStats {
+	stats := parallelizationStats{}
+	busy := map[string]int{}
+	for _, spec := range specInstances(run) {
+		instance := spec.ctx.testResult.Instance
+		stats.instancesDuration += instance.Duration
+		// Older runs don't report the machine, we can't tell how the specs were spread for these.
+		if instance.MachineID != "" {
+			busy[instance.MachineID] += instance.Duration
+		} else {
+			stats.unassigned++
+		}
+	}
+	stats.machines = len(busy)
+	first := true
+	for _, d := range busy {
+		if first || d > stats.longestMachine {
+			stats.longestMachine = d
+		}
+		if first || d < stats.shortestMachine {
+			stats.shortestMachine = d
+		}
+		first = false
+	}
+	return stats
+}
+
+// efficiency is the ratio between the time spent running specs and the time machines were booked for the run.
+// 1.0 means that every machine was busy during the whole run. It's unknown if some instances don't report their
+// machine, since their duration can't be spread across the machines.
+func (p parallelizationStats) efficiency(wallClock int) (float64, bool) {
+	if p.machines == 0 || p.unassigned > 0 || wallClock <= 0 {
+		return 0, false
+	}
+	return float64(p.instancesDuration) / float64(wallClock*p.machines), true
+}
+
+// scheduleError returns the difference in ms between the real and the predicted completion of the run.
+// A positive value means the run completed later than predicted.
+func scheduleError(run cypressclient.RunResult) (int, bool) {
+	if run.ScheduledToCompleteAt.IsZero() || run.StartTime.IsZero() {
+		return 0, false
+	}
+	completedAt := run.StartTime.UnixNano()/1e6 + int64(run.TotalDuration)
+	return int(completedAt - run.ScheduledToCompleteAt.UnixNano()/1e6), true
+}
+
+func (c *CypressDashboardCollector) processParallelization(metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult) {
+	labels := evaluateLabels(c.runInstanceLabels, metrics, run)
+	completedAt := runCompletedAt(run)
+
+	c.runLatest.AddAt(c.CypressRunParallelizationDisabled, promValueFromBool(run.ParallelizationDisabled), completedAt, labels...)
+	if delay, ok := scheduleError(run); ok {
+		c.runLatest.AddAt(c.CypressRunScheduleError, float64(delay), completedAt, labels...)
+	}
+
+	stats := runParallelization(run)
+	c.runLatest.AddAt(c.CypressRunInstancesDuration, float64(stats.instancesDuration), completedAt, labels...)
+	if stats.machines == 0 {
+		return
+	}
+	c.runLatest.AddAt(c.CypressRunMachines, float64(stats.machines), completedAt, labels...)
+	c.runLatest.AddAt(c.CypressRunMachineImbalance, float64(stats.longestMachine-stats.shortestMachine), completedAt, labels...)
+	if efficiency, ok := stats.efficiency(run.TotalDuration); ok {
+		c.runLatest.AddAt(c.CypressRunParallelEfficiency, efficiency, completedAt, labels...)
+	}
+}
@@ -0,0 +1,79 @@
+package cypresscollector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func testOnMachine(instanceID, machineID string, duration int) cypressclient.TestResult {
+	t := testOnInstance(instanceID, instanceID+".spec.js")
+	t.Instance.MachineID = machineID
+	t.Instance.Duration = duration
+	return t
+}
+
+func TestRunParallelization(t *testing.T) {
+	run := cypressclient.RunResult{TotalDuration: 1000}
+	run.TestResults.Nodes = []cypressclient.TestResult{
+		testOnMachine("1", "a", 600),
+		testOnMachine("1", "a", 600),
+		testOnMachine("2", "a", 300),
+		testOnMachine("3", "b", 500),
+	}
+
+	got := runParallelization(run)
+	want := parallelizationStats{
+		machines:          2,
+		instancesDuration: 1400,
+		longestMachine:    900,
+		shortestMachine:   500,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runParallelization() = %+v, want %+v", got, want)
+	}
+	if efficiency, ok := got.efficiency(run.TotalDuration); !ok || efficiency != 0.7 {
+		t.Errorf("parallelizationStats.efficiency() = %v, want 0.7", efficiency)
+	}
+}
+
+func TestRunParallelization_missingMachines(t *testing.T) {
+	run := cypressclient.RunResult{TotalDuration: 1000}
+	run.TestResults.Nodes = []cypressclient.TestResult{
+		testOnMachine("1", "a", 900),
+		testOnMachine("2", "", 900),
+		testOnMachine("3", "", 900),
+	}
+
+	got := runParallelization(run)
+	want := parallelizationStats{
+		machines:          1,
+		unassigned:        2,
+		instancesDuration: 2700,
+		longestMachine:    900,
+		shortestMachine:   900,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("runParallelization() = %+v, want %+v", got, want)
+	}
+	if efficiency, ok := got.efficiency(run.TotalDuration); ok {
+		t.Errorf("parallelizationStats.efficiency() = %v, should be unknown when some instances have no machine", efficiency)
+	}
+}
+
+func TestScheduleError(t *testing.T) {
+	start := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	run := cypressclient.RunResult{
+		StartTime:             start,
+		TotalDuration:         90 * 1000,
+		ScheduledToCompleteAt: start.Add(time.Minute),
+	}
+	if got, ok := scheduleError(run); !ok || got != 30*1000 {
+		t.Errorf("scheduleError() = %v, want %v", got, 30*1000)
+	}
+	if _, ok := scheduleError(cypressclient.RunResult{StartTime: start}); ok {
+		t.Errorf("scheduleError() should be undefined without a scheduled completion time")
+	}
+}
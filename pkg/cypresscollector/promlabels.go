@@ -3,279 +3,392 @@ package cypresscollector
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
 	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
 )
 
+// labelScope is the context a label needs to be evaluated.
+type labelScope int
+
+const (
+	// The label only depends on the project
+	projectScope labelScope = iota
+	// The label depends on a run, it can be evaluated for a run or a test of a run
+	runScope
+	// The label depends on a test
+	testScope
+)
+
 type labelsEvaluatorImpl struct {
 	label     func() string
+	scope     labelScope
 	evaluator func(cypressclient.StatsFromCypressDashboard, interface{}) string // Second argument is context specific
 }
 
-var RunsOrderedLabels []labelsEvaluatorImpl = []labelsEvaluatorImpl{
-	{
-		label: func() string { return "project_id" },
-		evaluator: func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return s.Data.Project.ID
-		},
-	},
-	{
-		label: func() string { return "project_name" },
-		evaluator: func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return s.Data.Project.Name
-		},
-	},
-	{
-		label: func() string { return "is_using_retries" },
-		evaluator: func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return func() string {
-				if s.Data.Project.IsUsingRetries {
-					return "1"
-				}
-				return "0"
-			}()
-		},
-	},
+type testContext struct {
+	runResult  cypressclient.RunResult
+	testResult cypressclient.TestResult
 }
 
-func castInterfaceToRunContext(i interface{}) cypressclient.RunResult {
-	res, ok := i.(cypressclient.RunResult)
-	if ok {
-		return res
+// runOf returns the run of a label context, which is either a run or a test of a run.
+func runOf(i interface{}) cypressclient.RunResult {
+	switch ctx := i.(type) {
+	case cypressclient.RunResult:
+		return ctx
+	case testContext:
+		return ctx.runResult
 	}
 	panic(fmt.Sprintf("Can't cast into RunResult %v - %v", reflect.TypeOf(i), i))
 }
 
-var RunInstanceOrderedLabels []labelsEvaluatorImpl = []labelsEvaluatorImpl{
+// testOf returns the test of a label context. For a run, it's the first test of the run, if any.
+func testOf(i interface{}) (cypressclient.TestResult, bool) {
+	switch ctx := i.(type) {
+	case cypressclient.RunResult:
+		if len(ctx.TestResults.Nodes) > 0 {
+			return ctx.TestResults.Nodes[0], true
+		}
+		return cypressclient.TestResult{}, false
+	case testContext:
+		return ctx.testResult, true
+	}
+	panic(fmt.Sprintf("Can't cast into TestResult %v - %v", reflect.TypeOf(i), i))
+}
+
+// labelRegistry holds all the labels the collector knows how to evaluate. Each metric family picks its labels from it.
+var labelRegistry = []labelsEvaluatorImpl{
 	{
-		func() string { return "project_id" },
-		func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
+		label: func() string { return "project_id" },
+		scope: projectScope,
+		evaluator: func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
 			return s.Data.Project.ID
 		},
 	},
 	{
-		func() string { return "project_name" },
-		func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
+		label: func() string { return "project_name" },
+		scope: projectScope,
+		evaluator: func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
 			return s.Data.Project.Name
 		},
 	},
 	{
-		func() string { return "is_using_retries" },
-		func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return func() string {
-				if s.Data.Project.IsUsingRetries {
-					return "1"
-				}
-				return "0"
-			}()
+		label: func() string { return "is_using_retries" },
+		scope: projectScope,
+		evaluator: func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
+			if s.Data.Project.IsUsingRetries {
+				return "1"
+			}
+			return "0"
 		},
 	},
 	{
-		func() string { return "ci_provider" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToRunContext(i)
-			return ctx.Ci.Provider
+		label: func() string { return "ci_provider" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			return runOf(i).Ci.Provider
 		},
 	},
 	{
-		func() string { return "os_name" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToRunContext(i)
-			if len(ctx.TestResults.Nodes) > 0 {
-				return fmt.Sprintf("%v_%v", ctx.TestResults.Nodes[0].Instance.Os.Name, ctx.TestResults.Nodes[0].Instance.Os.Version)
+		label: func() string { return "os_name" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			if test, ok := testOf(i); ok {
+				return fmt.Sprintf("%v_%v", test.Instance.Os.Name, test.Instance.Os.Version)
 			}
 			return "unknown"
 		},
 	},
 	{
-		func() string { return "browser_name" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToRunContext(i)
-			if len(ctx.TestResults.Nodes) > 0 {
-				return fmt.Sprintf("%v_%v", ctx.TestResults.Nodes[0].Instance.Browser.Name, ctx.TestResults.Nodes[0].Instance.Browser.Version)
+		label: func() string { return "browser_name" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			if test, ok := testOf(i); ok {
+				return fmt.Sprintf("%v_%v", test.Instance.Browser.Name, test.Instance.Browser.Version)
 			}
 			return "unknown"
 		},
 	},
 	{
-		func() string { return "git_branch" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToRunContext(i)
-			return ctx.Commit.Branch
+		label: func() string { return "git_branch" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			return runOf(i).Commit.Branch
 		},
 	},
-}
-
-type testContext struct {
-	runResult  cypressclient.RunResult
-	testResult cypressclient.TestResult
-}
-
-func castInterfaceToTestContext(i interface{}) testContext {
-	res, ok := i.(testContext)
-	if ok {
-		return res
-	}
-	panic(fmt.Sprintf("Can't cast into RunResult %v - %v", reflect.TypeOf(i), i))
-}
-
-var TestInstanceOrderedLabels []labelsEvaluatorImpl = []labelsEvaluatorImpl{
 	{
-		func() string { return "project_id" },
-		func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return s.Data.Project.ID
-		},
-	},
-	{
-		func() string { return "project_name" },
-		func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return s.Data.Project.Name
-		},
-	},
-	{
-		func() string { return "is_using_retries" },
-		func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-			return func() string {
-				if s.Data.Project.IsUsingRetries {
-					return "1"
-				}
-				return "0"
-			}()
-		},
-	},
-	{
-		func() string { return "ci_provider" },
-		func(s cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return ctx.runResult.Ci.Provider
-		},
-	},
-	{
-		func() string { return "os_name" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return fmt.Sprintf("%v_%v", ctx.testResult.Instance.Os.Name, ctx.testResult.Instance.Os.Version)
+		label: func() string { return "cypress_version" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			if v := runOf(i).CypressVersion; v != "" {
+				return v
+			}
+			return "unknown"
 		},
 	},
 	{
-		func() string { return "browser_name" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return fmt.Sprintf("%v_%v", ctx.testResult.Instance.Browser.Name, ctx.testResult.Instance.Browser.Version)
+		label: func() string { return "testing_type" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			if t := runOf(i).TestingType; t != "" {
+				return strings.ToLower(t)
+			}
+			return "unknown"
 		},
 	},
 	{
-		func() string { return "spec_file" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return ctx.testResult.Instance.Spec.ShortPath
+		label: func() string { return "tags" },
+		scope: runScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			return runTagsLabelValue(runOf(i).Tags)
 		},
 	},
 	{
-		func() string { return "name" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return strings.Join(ctx.testResult.TitleParts, " ")
+		label: func() string { return "spec_file" },
+		scope: testScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			test, _ := testOf(i)
+			return test.Instance.Spec.ShortPath
 		},
 	},
 	{
-		func() string { return "git_branch" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return ctx.runResult.Commit.Branch
+		label: func() string { return "name" },
+		scope: testScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			test, _ := testOf(i)
+			return strings.Join(test.TitleParts, " ")
 		},
 	},
 	{
-		func() string { return "run_group" },
-		func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-			ctx := castInterfaceToTestContext(i)
-			return ctx.testResult.Instance.Group.Name
+		label: func() string { return "run_group" },
+		scope: testScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
+			test, _ := testOf(i)
+			return test.Instance.Group.Name
 		},
 	},
 }
 
-func TestResultInstanceOrderedLabels(state string) []labelsEvaluatorImpl {
-	return []labelsEvaluatorImpl{
-		{
-			func() string { return "project_id" },
-			func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-				return s.Data.Project.ID
-			},
-		},
-		{
-			func() string { return "project_name" },
-			func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-				return s.Data.Project.Name
-			},
-		},
-		{
-			func() string { return "is_using_retries" },
-			func(s cypressclient.StatsFromCypressDashboard, _ interface{}) string {
-				return func() string {
-					if s.Data.Project.IsUsingRetries {
-						return "1"
-					}
-					return "0"
-				}()
-			},
-		},
-		{
-			func() string { return "ci_provider" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return ctx.runResult.Ci.Provider
-			},
-		},
-		{
-			func() string { return "os_name" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return fmt.Sprintf("%v_%v", ctx.testResult.Instance.Os.Name, ctx.testResult.Instance.Os.Version)
-			},
-		},
-		{
-			func() string { return "browser_name" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return fmt.Sprintf("%v_%v", ctx.testResult.Instance.Browser.Name, ctx.testResult.Instance.Browser.Version)
-			},
-		},
-		{
-			func() string { return "spec_file" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return ctx.testResult.Instance.Spec.ShortPath
-			},
-		},
-		{
-			func() string { return "name" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return strings.Join(ctx.testResult.TitleParts, " ")
-			},
-		},
-		{
-			func() string { return "git_branch" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return ctx.runResult.Commit.Branch
-			},
-		},
-		{
-			func() string { return "run_group" },
-			func(_ cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				ctx := castInterfaceToTestContext(i)
-				return ctx.testResult.Instance.Group.Name
-			},
-		},
-		{
-			func() string { return "state" },
-			func(s cypressclient.StatsFromCypressDashboard, i interface{}) string {
-				return state
-			},
+// runTagsLabelValue joins the tags of a run in a single label value. Tags are sorted, so that
+// the same set of tags always ends up in the same timeserie.
+func runTagsLabelValue(tags []cypressclient.RunTag) string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// LabelFamily is a group of metrics sharing the same labels.
+type LabelFamily string
+
+const (
+	// Project level metrics, like the availability of the exporter
+	ProjectFamily LabelFamily = "project"
+	// Metrics of the processed runs
+	RunFamily LabelFamily = "run"
+	// Overhead histograms of the runs. They're kept small on purpose, since each serie has a serie per bucket.
+	OverheadFamily LabelFamily = "overhead"
+	// Metrics of the processed tests. The test duration histogram uses them without the test name.
+	TestFamily LabelFamily = "test"
+	// Metrics of the spec files
+	SpecFamily LabelFamily = "spec"
+)
+
+// The most specific context each family is evaluated with
+var familyScopes = map[LabelFamily]labelScope{
+	ProjectFamily:  projectScope,
+	RunFamily:      runScope,
+	OverheadFamily: runScope,
+	TestFamily:     testScope,
+	SpecFamily:     testScope,
+}
+
+// DefaultFamilyLabels are the labels of the families missing from the LabelOptions.
+var DefaultFamilyLabels = map[LabelFamily][]string{
+	ProjectFamily:  {"project_id", "project_name", "is_using_retries"},
+	RunFamily:      {"project_id", "project_name", "is_using_retries", "ci_provider", "os_name", "browser_name", "git_branch", "cypress_version", "testing_type"},
+	OverheadFamily: {"project_id", "ci_provider", "git_branch"},
+	TestFamily:     {"project_id", "project_name", "is_using_retries", "ci_provider", "os_name", "browser_name", "spec_file", "name", "git_branch", "run_group"},
+	SpecFamily:     {"project_id", "project_name", "is_using_retries", "ci_provider", "os_name", "browser_name", "spec_file", "git_branch", "run_group"},
+}
+
+// Labels telling apart the tests and the spec files of a run. Without all of them, several values of a run have the
+// same labels, and their latest values are combined.
+var runEntryLabels = map[LabelFamily][]string{
+	TestFamily: {"spec_file", "name", "os_name", "browser_name", "run_group"},
+	SpecFamily: {"spec_file", "os_name", "browser_name", "run_group"},
+}
+
+// dropsRunEntryLabels tells whether the evaluators of a family lack some labels telling apart the entries of a run.
+func dropsRunEntryLabels(family LabelFamily, evaluators []labelsEvaluatorImpl) bool {
+	selected := map[string]bool{}
+	for _, label := range labelsInOrder(evaluators) {
+		selected[label] = true
+	}
+	for _, label := range runEntryLabels[family] {
+		if !selected[label] {
+			return true
+		}
+	}
+	return false
+}
+
+// LabelOptions selects the labels of each metric family. The dropped labels are aggregated : sums are added together,
+// and the latest values are the ones of the most recent run.
+type LabelOptions struct {
+	// Labels of each family, in order. Defaults to DefaultFamilyLabels for the missing families.
+	Families map[LabelFamily][]string
+	// Labels defined by the user, added after the labels of their families
+	Custom []CustomLabel
+	// Normalization of the git_branch label, in all the families
+	Branches BranchOptions
+	// Maximum number of label combinations of the run, test and spec families. Unlimited if missing.
+	Limits map[LabelFamily]int
+}
+
+// Families whose label combinations can be limited
+var limitedFamilies = map[LabelFamily]bool{RunFamily: true, TestFamily: true, SpecFamily: true}
+
+// Validate checks that the families and the labels exist, and that the labels can be evaluated for their family.
+func (o LabelOptions) Validate() error {
+	if err := o.Branches.Validate(); err != nil {
+		return err
+	}
+	for family, limit := range o.Limits {
+		if !limitedFamilies[family] {
+			return fmt.Errorf("the label combinations of family %q can't be limited, only the run, test and spec ones", family)
+		}
+		if limit < 0 {
+			return fmt.Errorf("limit of family %v must be positive", family)
+		}
+	}
+	families := make([]string, 0, len(o.Families))
+	for family := range o.Families {
+		families = append(families, string(family))
+	}
+	sort.Strings(families)
+	for _, family := range families {
+		if _, err := o.evaluators(LabelFamily(family)); err != nil {
+			return err
+		}
+	}
+	seen := map[string]bool{}
+	for _, custom := range o.Custom {
+		if seen[custom.Name] {
+			return fmt.Errorf("custom label %q is defined twice", custom.Name)
+		}
+		seen[custom.Name] = true
+		if _, err := custom.evaluator(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluators returns the evaluators of the labels of a family, followed by the custom labels of the family.
+func (o LabelOptions) evaluators(family LabelFamily) ([]labelsEvaluatorImpl, error) {
+	scope, ok := familyScopes[family]
+	if !ok {
+		return nil, fmt.Errorf("unknown label family %q, expected one of %v", family, strings.Join(labelFamilies(), ", "))
+	}
+	labels, ok := o.Families[family]
+	if !ok {
+		labels = DefaultFamilyLabels[family]
+	}
+	var branches *branchNormalizer
+	if o.Branches.Enabled() {
+		var err error
+		if branches, err = o.Branches.normalizer(); err != nil {
+			return nil, err
+		}
+	}
+
+	res := []labelsEvaluatorImpl{}
+	seen := map[string]bool{}
+	for _, label := range labels {
+		e, ok := registeredLabel(label)
+		if !ok {
+			return nil, fmt.Errorf("unknown label %q in family %v", label, family)
+		}
+		if e.scope > scope {
+			return nil, fmt.Errorf("label %q can't be used in family %v", label, family)
+		}
+		if seen[label] {
+			return nil, fmt.Errorf("label %q is repeated in family %v", label, family)
+		}
+		seen[label] = true
+		if label == "git_branch" && branches != nil {
+			e = branchLabel(branches)
+		}
+		res = append(res, e)
+	}
+	// The metrics of all the projects are exported together, they would collide without it
+	if !seen["project_id"] {
+		return nil, fmt.Errorf("label family %v requires the project_id label", family)
+	}
+
+	for _, custom := range o.Custom {
+		for _, f := range custom.Families {
+			if f != family {
+				continue
+			}
+			e, err := custom.evaluator()
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+func registeredLabel(label string) (labelsEvaluatorImpl, bool) {
+	for _, e := range labelRegistry {
+		if e.label() == label {
+			return e, true
+		}
+	}
+	return labelsEvaluatorImpl{}, false
+}
+
+func labelFamilies() []string {
+	res := []string{}
+	for family := range familyScopes {
+		res = append(res, string(family))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// withState returns a copy of the evaluators, with the `state` label on top.
+func withState(evaluators []labelsEvaluatorImpl, state string) []labelsEvaluatorImpl {
+	return append(append([]labelsEvaluatorImpl{}, evaluators...), labelsEvaluatorImpl{
+		label: func() string { return "state" },
+		scope: projectScope,
+		evaluator: func(_ cypressclient.StatsFromCypressDashboard, _ interface{}) string {
+			return state
 		},
+	})
+}
+
+// withoutLabels returns a copy of the evaluators, without the given labels.
+func withoutLabels(evaluators []labelsEvaluatorImpl, labels ...string) []labelsEvaluatorImpl {
+	res := []labelsEvaluatorImpl{}
+	for _, e := range evaluators {
+		drop := false
+		for _, l := range labels {
+			if e.label() == l {
+				drop = true
+			}
+		}
+		if !drop {
+			res = append(res, e)
+		}
 	}
+	return res
 }
 
 func labelsInOrder(evaluators []labelsEvaluatorImpl) []string {
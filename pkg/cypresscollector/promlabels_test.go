@@ -0,0 +1,176 @@
+package cypresscollector
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestLabelOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    LabelOptions
+		wantErr bool
+	}{
+		{"Should accept the default labels", LabelOptions{}, false},
+		{"Should accept a subset of the labels", LabelOptions{Families: map[LabelFamily][]string{TestFamily: {"project_id", "spec_file", "git_branch"}}}, false},
+		{"Should accept run labels on tests", LabelOptions{Families: map[LabelFamily][]string{SpecFamily: {"project_id", "cypress_version"}}}, false},
+		{"Should refuse an unknown family", LabelOptions{Families: map[LabelFamily][]string{"build": {"project_id"}}}, true},
+		{"Should refuse an unknown label", LabelOptions{Families: map[LabelFamily][]string{RunFamily: {"project_id", "commit"}}}, true},
+		{"Should refuse a test label on runs", LabelOptions{Families: map[LabelFamily][]string{RunFamily: {"project_id", "spec_file"}}}, true},
+		{"Should refuse a run label on the project", LabelOptions{Families: map[LabelFamily][]string{ProjectFamily: {"project_id", "git_branch"}}}, true},
+		{"Should refuse a repeated label", LabelOptions{Families: map[LabelFamily][]string{OverheadFamily: {"project_id", "git_branch", "git_branch"}}}, true},
+		{"Should require the project_id", LabelOptions{Families: map[LabelFamily][]string{OverheadFamily: {"git_branch"}}}, true},
+		{"Should refuse a custom label defined twice", LabelOptions{Custom: []CustomLabel{
+			{Name: "team", Template: "a", Families: []LabelFamily{RunFamily}},
+			{Name: "team", Template: "b", Families: []LabelFamily{TestFamily}},
+		}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("LabelOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLabelOptions_evaluators(t *testing.T) {
+	stats := cypressclient.StatsFromCypressDashboard{}
+	stats.Data.Project.ID = "7s5okt"
+	run := cypressclient.RunResult{}
+	run.Commit.Branch = "main"
+	test := cypressclient.TestResult{TitleParts: []string{"login", "works"}}
+	test.Instance.Spec.ShortPath = "login.spec.js"
+	test.Instance.Os.Name = "linux"
+	test.Instance.Os.Version = "5.4"
+
+	tests := []struct {
+		name   string
+		opts   LabelOptions
+		family LabelFamily
+		ctx    interface{}
+		want   []string
+	}{
+		{
+			name:   "Should evaluate the selected labels in order",
+			opts:   LabelOptions{Families: map[LabelFamily][]string{TestFamily: {"name", "project_id", "git_branch"}}},
+			family: TestFamily,
+			ctx:    testContext{run, test},
+			want:   []string{"login works", "7s5okt", "main"},
+		},
+		{
+			name:   "Should use the default labels of the missing families",
+			opts:   LabelOptions{Families: map[LabelFamily][]string{TestFamily: {"project_id"}}},
+			family: OverheadFamily,
+			ctx:    run,
+			want:   []string{"7s5okt", "", "main"},
+		},
+		{
+			name:   "Should use the test machine for tests",
+			opts:   LabelOptions{Families: map[LabelFamily][]string{SpecFamily: {"project_id", "os_name"}}},
+			family: SpecFamily,
+			ctx:    testContext{run, test},
+			want:   []string{"7s5okt", "linux_5.4"},
+		},
+		{
+			name: "Should add the custom labels after the family labels",
+			opts: LabelOptions{
+				Families: map[LabelFamily][]string{RunFamily: {"project_id"}},
+				Custom:   []CustomLabel{{Name: "release", Template: "{{ .Run.Commit.Branch }}", Families: []LabelFamily{RunFamily}}},
+			},
+			family: RunFamily,
+			ctx:    run,
+			want:   []string{"7s5okt", "main"},
+		},
+		{
+			name:   "Should fallback to unknown for runs without tests",
+			opts:   LabelOptions{Families: map[LabelFamily][]string{RunFamily: {"project_id", "os_name"}}},
+			family: RunFamily,
+			ctx:    run,
+			want:   []string{"7s5okt", "unknown"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluators, err := tt.opts.evaluators(tt.family)
+			if err != nil {
+				t.Fatalf("LabelOptions.evaluators() error = %v", err)
+			}
+			if got := evaluateLabels(evaluators, stats, tt.ctx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluateLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_runTagsLabelValue(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []cypressclient.RunTag
+		want string
+	}{
+		{"Should be empty without tags", nil, ""},
+		{"Should keep a single tag", []cypressclient.RunTag{{ID: "1", Name: "nightly"}}, "nightly"},
+		{"Should sort and join the tags", []cypressclient.RunTag{{ID: "1", Name: "smoke"}, {ID: "2", Name: "nightly"}, {ID: "3", Name: "chrome"}}, "chrome,nightly,smoke"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runTagsLabelValue(tt.tags); got != tt.want {
+				t.Errorf("runTagsLabelValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLabelOptions_runLabels(t *testing.T) {
+	stats := cypressclient.StatsFromCypressDashboard{}
+	stats.Data.Project.ID = "7s5okt"
+	migrated := cypressclient.RunResult{CypressVersion: "12.3.0", TestingType: "COMPONENT"}
+	migrated.Tags = []cypressclient.RunTag{{ID: "1", Name: "smoke"}, {ID: "2", Name: "nightly"}}
+
+	tests := []struct {
+		name string
+		opts LabelOptions
+		run  cypressclient.RunResult
+		want []string
+	}{
+		{
+			name: "Should evaluate the run labels",
+			opts: LabelOptions{Families: map[LabelFamily][]string{RunFamily: {"project_id", "cypress_version", "testing_type", "tags"}}},
+			run:  migrated,
+			want: []string{"7s5okt", "12.3.0", "component", "nightly,smoke"},
+		},
+		{
+			name: "Should fallback to unknown for older runs",
+			opts: LabelOptions{Families: map[LabelFamily][]string{RunFamily: {"project_id", "cypress_version", "testing_type", "tags"}}},
+			run:  cypressclient.RunResult{},
+			want: []string{"7s5okt", "unknown", "unknown", ""},
+		},
+		{
+			name: "Should drop the run labels left out of the family",
+			opts: LabelOptions{Families: map[LabelFamily][]string{RunFamily: {"project_id", "testing_type"}}},
+			run:  migrated,
+			want: []string{"7s5okt", "component"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluators, err := tt.opts.evaluators(RunFamily)
+			if err != nil {
+				t.Fatalf("LabelOptions.evaluators() error = %v", err)
+			}
+			if got := evaluateLabels(evaluators, stats, tt.run); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluateLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	defaults, _ := LabelOptions{}.evaluators(RunFamily)
+	for _, label := range labelsInOrder(defaults) {
+		if label == "tags" {
+			t.Errorf("tags is a default run label, it should be opt-in")
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package cypresscollector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Interval between two removals of the expired entries
+const sweepInterval = time.Minute
+
+// FamilyRetention overrides the retention of a family of metrics.
+type FamilyRetention struct {
+	// Time to keep an entry that isn't updated anymore. Defaults to the keepUntil of the collector.
+	KeepUntil time.Duration
+	// Maximum number of entries of each map of the family, eg the sums and the latest values of the tests. The least
+	// recently updated entry is evicted to make room for a new one. Unlimited if 0.
+	MaxEntries int
+}
+
+// RetentionOptions are the retentions of the run, test and spec families.
+type RetentionOptions map[LabelFamily]FamilyRetention
+
+func (o RetentionOptions) Validate() error {
+	for family, r := range o {
+		if !limitedFamilies[family] {
+			return fmt.Errorf("the retention of family %q can't be changed, only the run, test and spec ones", family)
+		}
+		if r.KeepUntil < 0 {
+			return fmt.Errorf("keepUntil of family %v must be positive", family)
+		}
+		if r.MaxEntries < 0 {
+			return fmt.Errorf("maxEntries of family %v must be positive", family)
+		}
+	}
+	return nil
+}
+
+// keepUntil returns the retention of a family, or the default one.
+func (o RetentionOptions) keepUntil(family LabelFamily, keepUntil time.Duration) time.Duration {
+	if r, ok := o[family]; ok && r.KeepUntil > 0 {
+		return r.KeepUntil
+	}
+	return keepUntil
+}
+
+// applyRetention sets the retention of the maps. It can be changed while the collector runs.
+func (c *CypressDashboardCollector) applyRetention(keepUntil time.Duration, retention RetentionOptions) {
+	c.runSummary.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.runLatest.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.testSummary.KeepUntil = retention.keepUntil(TestFamily, keepUntil)
+	c.testLatest.KeepUntil = retention.keepUntil(TestFamily, keepUntil)
+	c.specLatest.KeepUntil = retention.keepUntil(SpecFamily, keepUntil)
+	c.testDurationEWMA.KeepUntil = retention.keepUntil(TestFamily, keepUntil)
+	c.testFlakyRatio.KeepUntil = retention.keepUntil(TestFamily, keepUntil)
+	c.testFailureRatio.KeepUntil = retention.keepUntil(TestFamily, keepUntil)
+	c.runDurationMax.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.runSetupDuration.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.runInstanceGap.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.runTeardownDuration.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.runDurationHistogram.KeepUntil = retention.keepUntil(RunFamily, keepUntil)
+	c.testDurationHistogram.KeepUntil = retention.keepUntil(TestFamily, keepUntil)
+
+	c.runSummary.MaxEntries = retention[RunFamily].MaxEntries
+	c.runLatest.MaxEntries = retention[RunFamily].MaxEntries
+	c.testSummary.MaxEntries = retention[TestFamily].MaxEntries
+	c.testLatest.MaxEntries = retention[TestFamily].MaxEntries
+	c.specLatest.MaxEntries = retention[SpecFamily].MaxEntries
+	c.testDurationEWMA.MaxEntries = retention[TestFamily].MaxEntries
+	c.testFlakyRatio.MaxEntries = retention[TestFamily].MaxEntries
+	c.testFailureRatio.MaxEntries = retention[TestFamily].MaxEntries
+	c.runDurationMax.MaxEntries = retention[RunFamily].MaxEntries
+	c.runSetupDuration.MaxEntries = retention[RunFamily].MaxEntries
+	c.runInstanceGap.MaxEntries = retention[RunFamily].MaxEntries
+	c.runTeardownDuration.MaxEntries = retention[RunFamily].MaxEntries
+	c.runDurationHistogram.MaxEntries = retention[RunFamily].MaxEntries
+	c.testDurationHistogram.MaxEntries = retention[TestFamily].MaxEntries
+}
+
+// countEvictions returns the callback counting the evictions of the maps of a family.
+func (c *CypressDashboardCollector) countEvictions(family LabelFamily) func(string) {
+	return func(reason string) {
+		c.self.evictions.WithLabelValues(string(family), reason).Inc()
+	}
+}
+
+// Sweep removes the entries that weren't updated for the retention of their family. It's run in the background by
+// Run, collectors that are synced otherwise must call it.
+func (c *CypressDashboardCollector) Sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	evicted := c.runSummary.Sweep(now) + c.runLatest.Sweep(now) + c.testSummary.Sweep(now) + c.testLatest.Sweep(now) + c.specLatest.Sweep(now) +
+		c.testDurationEWMA.Sweep(now) + c.runDurationMax.Sweep(now) + c.testFlakyRatio.Sweep(now) + c.testFailureRatio.Sweep(now) +
+		c.runSetupDuration.Sweep(now) + c.runInstanceGap.Sweep(now) + c.runTeardownDuration.Sweep(now) +
+		c.runDurationHistogram.Sweep(now) + c.testDurationHistogram.Sweep(now)
+	if evicted > 0 {
+		logrus.Debugf("Removed %v expired entries of project %v", evicted, c.project)
+	}
+}
+
+func (c *CypressDashboardCollector) runSweeper(ctx context.Context, interval time.Duration) {
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			c.Sweep()
+		}
+	}
+}
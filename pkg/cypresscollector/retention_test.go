@@ -0,0 +1,93 @@
+package cypresscollector
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/clock"
+)
+
+func TestRetentionOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    RetentionOptions
+		wantErr bool
+	}{
+		{"Should accept no retention", nil, false},
+		{"Should accept the test family", RetentionOptions{TestFamily: {KeepUntil: time.Hour, MaxEntries: 1000}}, false},
+		{"Should refuse the project family", RetentionOptions{ProjectFamily: {KeepUntil: time.Hour}}, true},
+		{"Should refuse a negative keepUntil", RetentionOptions{RunFamily: {KeepUntil: -time.Hour}}, true},
+		{"Should refuse a negative maxEntries", RetentionOptions{SpecFamily: {MaxEntries: -1}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("RetentionOptions.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCypressDashboardCollector_applyRetention(t *testing.T) {
+	c := &CypressDashboardCollector{}
+	c.applyRetention(24*time.Hour, RetentionOptions{TestFamily: {KeepUntil: time.Hour, MaxEntries: 10}, SpecFamily: {MaxEntries: 5}})
+
+	if c.runSummary.KeepUntil != 24*time.Hour || c.runLatest.MaxEntries != 0 {
+		t.Errorf("run maps = %v, %v, want the default retention", c.runSummary.KeepUntil, c.runLatest.MaxEntries)
+	}
+	if c.testSummary.KeepUntil != time.Hour || c.testLatest.KeepUntil != time.Hour || c.testLatest.MaxEntries != 10 {
+		t.Errorf("test maps = %v, %v, %v, want the retention of the family", c.testSummary.KeepUntil, c.testLatest.KeepUntil, c.testLatest.MaxEntries)
+	}
+	if c.specLatest.KeepUntil != 24*time.Hour || c.specLatest.MaxEntries != 5 {
+		t.Errorf("spec map = %v, %v, want the default keepUntil and the family maxEntries", c.specLatest.KeepUntil, c.specLatest.MaxEntries)
+	}
+}
+
+func TestCypressDashboardCollector_Sweep(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(dashboardResponse))
+	}))
+	defer server.Close()
+
+	endpoint, _ := url.Parse(server.URL)
+	c := clock.NewFake(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	opts := CollectorOptions{Clock: c, Retention: RetentionOptions{TestFamily: {KeepUntil: time.Hour}}}
+	collector, err := NewCypressDashboardCollector(*endpoint, "project", "", "", int64(24*time.Hour), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collector.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.runLatest.Map()) == 0 || len(collector.testLatest.Map()) == 0 || testutil.CollectAndCount(collector.CypressTestDurationHistogram) == 0 {
+		t.Fatal("CypressDashboardCollector.Sync() should process the run")
+	}
+
+	c.Advance(59 * time.Minute)
+	collector.Sweep()
+	if len(collector.testLatest.Map()) == 0 {
+		t.Errorf("CypressDashboardCollector.Sweep() removed the tests before their retention")
+	}
+
+	c.Advance(2 * time.Minute)
+	collector.Sweep()
+	if len(collector.testLatest.Map()) != 0 || len(collector.testSummary.Map()) != 0 {
+		t.Errorf("CypressDashboardCollector.Sweep() should remove the tests after their retention")
+	}
+	if got := testutil.CollectAndCount(collector.CypressTestDurationHistogram); got != 0 {
+		t.Errorf("CypressDashboardCollector.Sweep() left %v series of cypress_test_duration_seconds after the test retention", got)
+	}
+	if len(collector.runLatest.Map()) == 0 || testutil.CollectAndCount(collector.CypressRunDurationHistogram) == 0 {
+		t.Errorf("CypressDashboardCollector.Sweep() removed the runs, kept for 24h")
+	}
+	if err := collector.Ready(30 * time.Minute); err == nil {
+		t.Errorf("CypressDashboardCollector.Ready() should fail, the last sync was an hour ago")
+	}
+}
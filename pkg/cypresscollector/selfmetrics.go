@@ -0,0 +1,148 @@
+package cypresscollector
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+// Reasons for skipping a run
+const (
+	skippedAlreadyProcessed = "already_processed"
+	skippedNotCompleted     = "not_completed"
+)
+
+// exporterMetrics are the metrics about the exporter itself, to monitor its health.
+type exporterMetrics struct {
+	requestDuration *prometheus.HistogramVec
+	errors          *prometheus.CounterVec
+	lastSuccess     prometheus.Gauge
+	lastBuild       prometheus.Gauge
+	runsProcessed   prometheus.Counter
+	testsProcessed  prometheus.Counter
+	runsSkipped     *prometheus.CounterVec
+	authentications *prometheus.CounterVec
+	series          *prometheus.GaugeVec
+	// Label combinations folded into the overflow, by family
+	droppedCombinations *prometheus.CounterVec
+	// Entries removed from the maps, by family and reason
+	evictions *prometheus.CounterVec
+}
+
+func newExporterMetrics(project string) *exporterMetrics {
+	constLabels := prometheus.Labels{"project_id": project}
+	return &exporterMetrics{
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "cypress_dashboard_exporter_graphql_request_duration_seconds",
+			Help:        "Duration of the GraphQL requests to the dashboard",
+			ConstLabels: constLabels,
+		}, []string{"code"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_errors_total",
+			Help:        "Errors while polling the dashboard, by type",
+			ConstLabels: constLabels,
+		}, []string{"type"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "cypress_dashboard_exporter_last_success_timestamp_seconds",
+			Help:        "Time of the last successful poll of the dashboard",
+			ConstLabels: constLabels,
+		}),
+		lastBuild: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "cypress_dashboard_exporter_last_build_number",
+			Help:        "Build number of the last processed run",
+			ConstLabels: constLabels,
+		}),
+		runsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_runs_processed_total",
+			Help:        "Number of runs processed by the exporter",
+			ConstLabels: constLabels,
+		}),
+		testsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_tests_processed_total",
+			Help:        "Number of test results processed by the exporter",
+			ConstLabels: constLabels,
+		}),
+		runsSkipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_runs_skipped_total",
+			Help:        "Number of runs returned by the dashboard but not processed, by reason",
+			ConstLabels: constLabels,
+		}, []string{"reason"}),
+		authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_authentications_total",
+			Help:        "Number of authentications to the dashboard, which happen when the session expired",
+			ConstLabels: constLabels,
+		}, []string{"result"}),
+		series: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "cypress_dashboard_exporter_series",
+			Help:        "Number of series currently exported, by metric family",
+			ConstLabels: constLabels,
+		}, []string{"family"}),
+		droppedCombinations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_label_combinations_dropped_total",
+			Help:        "Number of label combinations folded into __overflow__, because their family reached its limit",
+			ConstLabels: constLabels,
+		}, []string{"family"}),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "cypress_dashboard_exporter_evictions_total",
+			Help:        "Number of entries removed from memory, by family and reason : expired or lru",
+			ConstLabels: constLabels,
+		}, []string{"family", "reason"}),
+	}
+}
+
+func (m *exporterMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.requestDuration,
+		m.errors,
+		m.lastSuccess,
+		m.lastBuild,
+		m.runsProcessed,
+		m.testsProcessed,
+		m.runsSkipped,
+		m.authentications,
+		m.series,
+		m.droppedCombinations,
+		m.evictions,
+	}
+}
+
+func (m *exporterMetrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *exporterMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// instrument observes the requests and authentications of the client.
+func (m *exporterMetrics) instrument(cli *cypressclient.CypressDashboardMetricsClient) {
+	cli.Instrument(
+		func(rt http.RoundTripper) http.RoundTripper {
+			return promhttp.InstrumentRoundTripperDuration(m.requestDuration, rt)
+		},
+		func(err error) {
+			if err != nil {
+				m.authentications.WithLabelValues("failure").Inc()
+				return
+			}
+			m.authentications.WithLabelValues("success").Inc()
+		},
+	)
+}
+
+// countError counts an error of the client by its type.
+func (m *exporterMetrics) countError(err error) {
+	var reqErr *cypressclient.RequestError
+	if errors.As(err, &reqErr) {
+		m.errors.WithLabelValues(string(reqErr.Type)).Inc()
+		return
+	}
+	m.errors.WithLabelValues("other").Inc()
+}
@@ -0,0 +1,53 @@
+package cypresscollector
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func TestExporterMetrics_countError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantType string
+	}{
+		{"Request errors should be counted by type", &cypressclient.RequestError{Type: cypressclient.NetworkError, Err: errors.New("timeout")}, "network"},
+		{"Wrapped request errors should be counted by type", fmt.Errorf("polling : %w", &cypressclient.RequestError{Type: cypressclient.GraphQLError, Err: errors.New("denied")}), "graphql"},
+		{"Unknown errors should be counted as other", errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newExporterMetrics("project")
+			m.countError(tt.err)
+			if got := testutil.ToFloat64(m.errors.WithLabelValues(tt.wantType)); got != 1 {
+				t.Errorf("exporterMetrics.countError() counted %v errors of type %v, want 1", got, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestMetricNames_series(t *testing.T) {
+	names := newMetricNames(MetricNamesBoth)
+	key := names.desc("cypress_run_duration_seconds_last", "cypress_run_duration_ms_last", "Duration", []string{"branch"}, msToSec)
+
+	ch := make(chan prometheus.Metric, 10)
+	names.emit(ch, key, prometheus.GaugeValue, 1500, []string{"main"}, time.Time{}, nil)
+	names.emit(ch, key, prometheus.GaugeValue, 1500, []string{"develop"}, time.Time{}, nil)
+	close(ch)
+
+	want := map[string]int{"cypress_run_duration_seconds_last": 2, "cypress_run_duration_ms_last": 2}
+	if !reflect.DeepEqual(names.series, want) {
+		t.Errorf("metricNames.series = %v, want %v", names.series, want)
+	}
+	names.resetSeries()
+	if len(names.series) != 0 {
+		t.Errorf("metricNames.resetSeries() kept %v", names.series)
+	}
+}
@@ -0,0 +1,56 @@
+package cypresscollector
+
+import (
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+// specInstance is a spec file executed by a run instance ( ie one machine ), rebuilt from the test results.
+type specInstance struct {
+	ctx   testContext // Context of the first test of the instance, used to evaluate labels
+	tests int
+}
+
+// specInstances deduplicates the run instances found in the test results of a run, and count their tests.
+// Instances are returned in the order they're first seen, so the processing stays deterministic.
+func specInstances(run cypressclient.RunResult) []*specInstance {
+	byID := map[string]*specInstance{}
+	res := []*specInstance{}
+	for _, test := range run.TestResults.Nodes {
+		id := test.Instance.ID
+		if id == "" {
+			continue
+		}
+		spec, ok := byID[id]
+		if !ok {
+			spec = &specInstance{ctx: testContext{run, test}}
+			byID[id] = spec
+			res = append(res, spec)
+		}
+		spec.tests++
+	}
+	return res
+}
+
+func (c *CypressDashboardCollector) processSpecs(metrics cypressclient.StatsFromCypressDashboard, run cypressclient.RunResult) {
+	completedAt := runCompletedAt(run)
+	for _, spec := range specInstances(run) {
+		instance := spec.ctx.testResult.Instance
+
+		c.specLatest.AddAt(c.CypressSpecDuration, float64(instance.Duration), completedAt, evaluateLabels(c.specLabels, metrics, spec.ctx)...)
+		c.specLatest.AddAt(c.CypressSpecTests, float64(spec.tests), completedAt, evaluateLabels(c.specLabels, metrics, spec.ctx)...)
+
+		matched := false
+		for _, value := range cypressclient.AllInstanceStatus() {
+			s := promValueFromState(instance.Status, value.String())
+			if s == 1.0 {
+				matched = true
+			}
+			c.specLatest.AddAt(c.CypressSpecStatus, s, completedAt, evaluateLabels(withState(c.specLabels, value.String()), metrics, spec.ctx)...)
+		}
+		other := 0.0
+		if !matched {
+			other = 1.0
+		}
+		c.specLatest.AddAt(c.CypressSpecStatus, other, completedAt, evaluateLabels(withState(c.specLabels, cypressclient.Other.String()), metrics, spec.ctx)...)
+	}
+}
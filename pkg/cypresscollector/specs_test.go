@@ -0,0 +1,69 @@
+package cypresscollector
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypressclient"
+)
+
+func testOnInstance(instanceID, spec string) cypressclient.TestResult {
+	t := cypressclient.TestResult{}
+	t.Instance.ID = instanceID
+	t.Instance.Spec.ShortPath = spec
+	return t
+}
+
+func TestSpecInstances(t *testing.T) {
+	run := cypressclient.RunResult{}
+	run.TestResults.Nodes = []cypressclient.TestResult{
+		testOnInstance("1", "login.spec.js"),
+		testOnInstance("2", "cart.spec.js"),
+		testOnInstance("1", "login.spec.js"),
+		testOnInstance("", "unknown.spec.js"),
+		testOnInstance("1", "login.spec.js"),
+	}
+
+	got := specInstances(run)
+	want := []struct {
+		spec  string
+		tests int
+	}{
+		{"login.spec.js", 3},
+		{"cart.spec.js", 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("specInstances() returned %v instances, want %v", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].ctx.testResult.Instance.Spec.ShortPath != w.spec || got[i].tests != w.tests {
+			t.Errorf("specInstances()[%v] = %v with %v tests, want %v with %v tests", i,
+				got[i].ctx.testResult.Instance.Spec.ShortPath, got[i].tests, w.spec, w.tests)
+		}
+	}
+}
+
+func TestCypressDashboardCollector_processSpecs_status(t *testing.T) {
+	c, err := NewCypressDashboardCollector(url.URL{}, "project", "", "", int64(24*time.Hour), CollectorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := cypressclient.RunResult{CompletedAt: time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)}
+	test := testOnInstance("1", "login.spec.js")
+	test.Instance.Status = cypressclient.Errored.String()
+	run.TestResults.Nodes = []cypressclient.TestResult{test}
+	c.processSpecs(cypressclient.StatsFromCypressDashboard{}, run)
+
+	got := map[string]float64{}
+	for k, v := range c.specLatest.Map() {
+		if k.Prom == c.CypressSpecStatus {
+			got[v.Labels[len(v.Labels)-1]] = v.Value
+		}
+	}
+	want := map[string]float64{"PASSED": 0, "FAILED": 0, "ERRORED": 1, "TIMEDOUT": 0, "NOTESTS": 0, "CANCELLED": 0, "OTHER": 0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cypress_spec_status_last by state = %v, want %v", got, want)
+	}
+}
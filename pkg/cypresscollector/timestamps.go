@@ -0,0 +1,82 @@
+package cypresscollector
+
+import (
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+	"github.com/sirupsen/logrus"
+)
+
+// Instant queries, and so alerts, only see the samples of the last 5 minutes ( the default lookback delta of
+// prometheus ). Older samples are only visible to range queries.
+const DefaultTimestampMaxAge = 5 * time.Minute
+
+// TimestampOptions configures the timestamps attached to the `_last` gauges.
+type TimestampOptions struct {
+	// Send the `_last` gauges with the completion time of their run, instead of the scrape time
+	Enabled bool
+	// Timestamps older than this are moved forward, so that prometheus doesn't reject them. Above the lookback delta
+	// of prometheus, the moved samples are only visible to range queries.
+	MaxAge time.Duration
+}
+
+type emittedTimestamp struct {
+	observed time.Time
+	emitted  time.Time
+	value    float64
+}
+
+// timestampGuard makes sure that the timestamps of a serie only move forward.
+// Prometheus rejects the samples older than the latest one of a serie ( out of order ), the samples too far in the
+// past ( out of bounds ), and a different value for an existing timestamp ( duplicate sample ).
+type timestampGuard struct {
+	maxAge time.Duration
+	series map[metricsmap.Key]emittedTimestamp
+	seen   map[metricsmap.Key]bool
+}
+
+func newTimestampGuard(maxAge time.Duration) *timestampGuard {
+	if maxAge <= 0 {
+		maxAge = DefaultTimestampMaxAge
+	}
+	return &timestampGuard{
+		maxAge: maxAge,
+		series: map[metricsmap.Key]emittedTimestamp{},
+		seen:   map[metricsmap.Key]bool{},
+	}
+}
+
+// timestamp returns the timestamp to send for a value observed at the given time.
+func (g *timestampGuard) timestamp(key metricsmap.Key, value float64, observed time.Time, now time.Time) time.Time {
+	g.seen[key] = true
+	previous, ok := g.series[key]
+	// Nothing changed since the last scrape, send exactly the same sample
+	if ok && previous.observed.Equal(observed) && previous.value == value {
+		return previous.emitted
+	}
+
+	emitted := observed
+	if oldest := now.Add(-g.maxAge); emitted.Before(oldest) {
+		logrus.Debugf("Timestamp %v of %v is too old, sending it at %v", observed, key.Prom, oldest)
+		emitted = oldest
+	}
+	if emitted.After(now) {
+		emitted = now
+	}
+	if ok && !emitted.After(previous.emitted) {
+		emitted = previous.emitted.Add(time.Millisecond)
+	}
+
+	g.series[key] = emittedTimestamp{observed, emitted, value}
+	return emitted
+}
+
+// sweep forgets the series that haven't been sent since the last sweep.
+func (g *timestampGuard) sweep() {
+	for key := range g.series {
+		if !g.seen[key] {
+			delete(g.series, key)
+		}
+	}
+	g.seen = map[metricsmap.Key]bool{}
+}
@@ -0,0 +1,39 @@
+package cypresscollector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rguilmont/cypress-dashboard-exporter/pkg/cypresscollector/metricsmap"
+)
+
+func TestTimestampGuard_timestamp(t *testing.T) {
+	now := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+	key := metricsmap.Key{Hash: metricsmap.StringSliceHash([]string{"serie"})}
+	g := newTimestampGuard(time.Hour)
+
+	steps := []struct {
+		name     string
+		value    float64
+		observed time.Time
+		now      time.Time
+		want     time.Time
+	}{
+		{"Old runs should be moved to the max age", 1, now.Add(-3 * time.Hour), now, now.Add(-time.Hour)},
+		{"Same sample should be sent again as-is", 1, now.Add(-3 * time.Hour), now.Add(time.Minute), now.Add(-time.Hour)},
+		{"Recent runs should keep their timestamp", 2, now.Add(-time.Minute), now, now.Add(-time.Minute)},
+		{"Older runs should never go back in time", 3, now.Add(-2 * time.Minute), now, now.Add(-time.Minute + time.Millisecond)},
+		{"Future runs should be sent with the scrape time", 4, now.Add(time.Hour), now, now},
+	}
+	for _, s := range steps {
+		if got := g.timestamp(key, s.value, s.observed, s.now); !got.Equal(s.want) {
+			t.Errorf("%v : timestampGuard.timestamp() = %v, want %v", s.name, got, s.want)
+		}
+	}
+
+	g.sweep()
+	g.sweep()
+	if len(g.series) != 0 {
+		t.Errorf("timestampGuard.sweep() should forget series that aren't sent anymore")
+	}
+}
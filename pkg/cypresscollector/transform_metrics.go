@@ -18,3 +18,10 @@ func promValueFromState(value string, expected string) float64 {
 		return 0.0
 	}
 }
+
+func promValueFromBool(value bool) float64 {
+	if value {
+		return 1.0
+	}
+	return 0.0
+}
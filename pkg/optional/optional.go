@@ -1,74 +1,38 @@
 package optional
 
-import (
-	"reflect"
-	"time"
-
-	"github.com/sirupsen/logrus"
-)
-
-// I NEED GENERICS PLEEEAAAASE :)
-type Nothing struct{}
-
-// Optional time.Time
-
-// Option of Time
-type OptionalTime interface {
-	rmAj2vb2A98wYaRuT3C2wX5TV8y67tEc()
+// Option is a value that may be missing.
+type Option[T any] struct {
+	value T
+	ok    bool
 }
 
-type SomeTime time.Time
-
-func (SomeTime) rmAj2vb2A98wYaRuT3C2wX5TV8y67tEc() {}
-
-func (Nothing) rmAj2vb2A98wYaRuT3C2wX5TV8y67tEc() {}
-
-func NewOptionalTime(t *time.Time) OptionalTime {
-	if t == nil {
-		return Nothing{}
-	} else {
-		return SomeTime(*t)
-	}
+// Some returns an option holding the value.
+func Some[T any](value T) Option[T] {
+	return Option[T]{value: value, ok: true}
 }
 
-func OrElseTime(o OptionalTime, def time.Time) time.Time {
-	switch value := o.(type) {
-	case Nothing:
-		return def
-	case SomeTime:
-		return time.Time(value)
-	default:
-		logrus.Panicln("Impossible switch condition : unknown type %v - value %v", reflect.TypeOf(value), value)
-	}
-	return time.Time{} // Should never enter here. Just make it compile.
+// None returns an empty option.
+func None[T any]() Option[T] {
+	return Option[T]{}
 }
 
-type OptionalInt interface {
-	amAj2vb2A98wYaRuT3C2wX5TV8y67tEc()
+// FromPointer returns an option holding the pointed value, empty if the pointer is nil.
+func FromPointer[T any](value *T) Option[T] {
+	if value == nil {
+		return None[T]()
+	}
+	return Some(*value)
 }
 
-type SomeInt int
-
-func (SomeInt) amAj2vb2A98wYaRuT3C2wX5TV8y67tEc() {}
-
-func (Nothing) amAj2vb2A98wYaRuT3C2wX5TV8y67tEc() {}
-
-func NewOptionalInt(t *int) OptionalInt {
-	if t == nil {
-		return Nothing{}
-	} else {
-		return SomeInt(*t)
-	}
+// Get returns the value, and whether there is one.
+func (o Option[T]) Get() (T, bool) {
+	return o.value, o.ok
 }
 
-func OrElseInt(o OptionalInt, def int) int {
-	switch value := o.(type) {
-	case Nothing:
+// OrElse returns the value, or def if there is none.
+func (o Option[T]) OrElse(def T) T {
+	if !o.ok {
 		return def
-	case SomeInt:
-		return int(value)
-	default:
-		logrus.Panicln("Impossible switch condition : unknown type %v - value %v", reflect.TypeOf(value), value)
 	}
-	return 0 // Should never enter here. Just make it compile.
+	return o.value
 }
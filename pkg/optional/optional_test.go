@@ -0,0 +1,40 @@
+package optional
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOption_OrElse(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	ten := 10
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"Should return the value", Some(3).OrElse(5), 3},
+		{"Should return the default without value", None[int]().OrElse(5), 5},
+		{"Should keep a zero value", Some(0).OrElse(5), 0},
+		{"Should return the pointed value", FromPointer(&ten).OrElse(5), 10},
+		{"Should return the default with a nil pointer", FromPointer[int](nil).OrElse(5), 5},
+		{"Should hold any type", Some(now).OrElse(time.Time{}), now},
+		{"Should be empty by default", Option[string]{}.OrElse("default"), "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("Option.OrElse() = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOption_Get(t *testing.T) {
+	if v, ok := Some("a").Get(); !ok || v != "a" {
+		t.Errorf("Some().Get() = %v, %v, want a, true", v, ok)
+	}
+	if v, ok := None[string]().Get(); ok || v != "" {
+		t.Errorf("None().Get() = %q, %v, want the zero value and false", v, ok)
+	}
+}
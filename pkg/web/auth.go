@@ -0,0 +1,83 @@
+package web
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt is slow on purpose, so the results of the last checks are cached, like the exporter-toolkit does.
+const authCacheSize = 100
+
+// Compared when the user is unknown, so that the response time doesn't tell which users exist
+var dummyHash = []byte("$2a$10$fHjh6ALTWl9Co9514THDJOCeo/MUNy4wYp6ifVockTiAmd46h2K4G")
+
+type authCache struct {
+	mu      sync.Mutex
+	results map[[sha256.Size]byte]bool
+}
+
+func newAuthCache() *authCache {
+	return &authCache{results: map[[sha256.Size]byte]bool{}}
+}
+
+func (c *authCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.results = map[[sha256.Size]byte]bool{}
+}
+
+// check compares a secret with a bcrypt hash, or returns the cached result.
+func (c *authCache) check(hash []byte, secret string) bool {
+	// The hash is part of the key, so that a changed password isn't accepted anymore
+	key := sha256.Sum256([]byte(string(hash) + "\x00" + secret))
+	c.mu.Lock()
+	res, ok := c.results[key]
+	c.mu.Unlock()
+	if ok {
+		return res
+	}
+
+	res = bcrypt.CompareHashAndPassword(hash, []byte(secret)) == nil
+	c.mu.Lock()
+	if len(c.results) >= authCacheSize {
+		c.results = map[[sha256.Size]byte]bool{}
+	}
+	c.results[key] = res
+	c.mu.Unlock()
+	return res
+}
+
+// authenticate checks the basic auth or the bearer token of the request. Requests are accepted if the configuration
+// has neither users nor tokens.
+func (c *authCache) authenticate(config *Config, r *http.Request) bool {
+	if len(config.Users) == 0 && len(config.BearerAuthTokens) == 0 {
+		return true
+	}
+
+	if token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); token != r.Header.Get("Authorization") {
+		if len(config.BearerAuthTokens) == 0 {
+			return false
+		}
+		for _, hash := range config.BearerAuthTokens {
+			if c.check([]byte(hash), token) {
+				return true
+			}
+		}
+		return false
+	}
+
+	user, password, ok := r.BasicAuth()
+	if !ok || len(config.Users) == 0 {
+		return false
+	}
+	hash, known := config.Users[user]
+	if !known {
+		c.check(dummyHash, password)
+		return false
+	}
+	return c.check([]byte(hash), password)
+}
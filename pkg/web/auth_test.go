@@ -0,0 +1,52 @@
+package web
+
+import (
+	"net/http"
+	"testing"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+const (
+	// bcrypt hashes of "secret" and "token"
+	secretHash = "$2a$10$4YW3pQXv1ipT69HzkVtRcuj0Tar/XwYFlVKTWJZ4Hfr04zzNdY96a"
+	tokenHash  = "$2a$10$YyoHgJPoXGqQ7NfdVsJtd.hNOawKNeC2X.BEifSrCA5RX1LKiwxDS"
+)
+
+func TestAuthCache_authenticate(t *testing.T) {
+	both := &Config{
+		Config:           web.Config{Users: map[string]config_util.Secret{"prometheus": secretHash}},
+		BearerAuthTokens: map[string]config_util.Secret{"grafana": tokenHash},
+	}
+	basicOnly := &Config{Config: web.Config{Users: map[string]config_util.Secret{"prometheus": secretHash}}}
+
+	tests := []struct {
+		name    string
+		config  *Config
+		request func(r *http.Request)
+		want    bool
+	}{
+		{"Should accept everything without users or tokens", &Config{}, func(r *http.Request) {}, true},
+		{"Should refuse a request without credentials", both, func(r *http.Request) {}, false},
+		{"Should accept a valid user", both, func(r *http.Request) { r.SetBasicAuth("prometheus", "secret") }, true},
+		{"Should refuse a wrong password", both, func(r *http.Request) { r.SetBasicAuth("prometheus", "wrong") }, false},
+		{"Should refuse an unknown user", both, func(r *http.Request) { r.SetBasicAuth("grafana", "secret") }, false},
+		{"Should accept a valid token", both, func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, true},
+		{"Should refuse a wrong token", both, func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, false},
+		{"Should refuse tokens if none are configured", basicOnly, func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newAuthCache()
+			r, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+			tt.request(r)
+			// The second check comes from the cache
+			for i := 0; i < 2; i++ {
+				if got := cache.authenticate(tt.config, r); got != tt.want {
+					t.Errorf("authCache.authenticate() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
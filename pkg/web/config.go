@@ -0,0 +1,98 @@
+package web
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/exporter-toolkit/web"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of the web configuration file. It uses the format of the Prometheus exporter-toolkit, eg :
+//
+//	tls_server_config:
+//	  cert_file: server.crt
+//	  key_file: server.key
+//	basic_auth_users:
+//	  prometheus: $2y$10$...
+//	bearer_auth_tokens:
+//	  grafana: $2y$10$...
+//
+// bearer_auth_tokens isn't part of the exporter-toolkit format, it maps a name to the bcrypt hash of a token.
+type Config struct {
+	web.Config       `yaml:",inline"`
+	BearerAuthTokens map[string]config_util.Secret `yaml:"bearer_auth_tokens"`
+}
+
+// Same headers as the exporter-toolkit, the other ones could break the exporter
+var allowedHeaders = map[string]bool{
+	"Strict-Transport-Security": true,
+	"X-Content-Type-Options":    true,
+	"X-Frame-Options":           true,
+	"X-XSS-Protection":          true,
+	"Content-Security-Policy":   true,
+}
+
+// LoadConfig reads and validates a web configuration file, with the defaults of the exporter-toolkit.
+// Relative paths in the file are resolved from the directory of the file.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{Config: web.Config{
+		TLSConfig: web.TLSConfig{
+			MinVersion:               tls.VersionTLS12,
+			MaxVersion:               tls.VersionTLS13,
+			PreferServerCipherSuites: true,
+		},
+		HTTPConfig: web.HTTPConfig{HTTP2: true},
+	}}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid web configuration %v : %v", path, err)
+	}
+	c.TLSConfig.SetDirectory(filepath.Dir(path))
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid web configuration %v : %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) Validate() error {
+	for user, hash := range c.Users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("basic_auth_users.%v : invalid bcrypt hash : %v", user, err)
+		}
+	}
+	for name, hash := range c.BearerAuthTokens {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("bearer_auth_tokens.%v : invalid bcrypt hash : %v", name, err)
+		}
+	}
+	for header := range c.HTTPConfig.Header {
+		if !allowedHeaders[header] {
+			return fmt.Errorf("http_server_config.headers : header %v can't be set", header)
+		}
+	}
+	if c.tlsEnabled() {
+		if _, err := web.ConfigToTLSConfig(&c.TLSConfig); err != nil {
+			return fmt.Errorf("tls_server_config : %v", err)
+		}
+	}
+	return nil
+}
+
+// tlsEnabled tells whether the file has a TLS configuration, the exporter-toolkit serves HTTP otherwise.
+func (c *Config) tlsEnabled() bool {
+	t := c.TLSConfig
+	return t.TLSCertPath != "" || t.TLSKeyPath != "" || t.ClientAuth != "" || t.ClientCAs != ""
+}
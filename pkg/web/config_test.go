@@ -0,0 +1,135 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for the given name, and its key.
+func writeCertificate(t *testing.T, dir, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(filepath.Join(dir, "server.crt"), certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "server.key"), keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeConfig(t *testing.T, dir, content string) string {
+	path := filepath.Join(dir, "web.yml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, dir, "localhost")
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"Should accept an empty file", "", ""},
+		{"Should accept TLS with relative paths", "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: TLS13\n", ""},
+		{"Should accept users and tokens", "basic_auth_users:\n  prometheus: " + secretHash + "\nbearer_auth_tokens:\n  grafana: " + tokenHash + "\n", ""},
+		{"Should reject unknown fields", "tls_server_config:\n  cert: server.crt\n", "field cert not found"},
+		{"Should require a key", "tls_server_config:\n  cert_file: server.crt\n", "missing key_file"},
+		{"Should reject plain passwords", "basic_auth_users:\n  prometheus: secret\n", "basic_auth_users.prometheus : invalid bcrypt hash"},
+		{"Should reject unknown TLS versions", "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  min_version: SSL3\n", "unknown TLS version: SSL3"},
+		{"Should reject unknown client auth types", "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth_type: VerifyClientCert\n", "Invalid ClientAuth: VerifyClientCert"},
+		{"Should reject other headers", "http_server_config:\n  headers:\n    Content-Type: text/plain\n", "header Content-Type can't be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, dir, tt.content))
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("LoadConfig() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("LoadConfig() error = %v, should contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigLoader_certificate(t *testing.T) {
+	dir := t.TempDir()
+	writeCertificate(t, dir, "first")
+	loader, err := newConfigLoader(writeConfig(t, dir, "tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func() string {
+		config, err := loader.getConfigForClient(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate = %v, want first", got)
+	}
+
+	// Renew the certificate, with a different modification time
+	writeCertificate(t, dir, "renewed")
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{"server.crt", "server.key"} {
+		if err := os.Chtimes(filepath.Join(dir, f), later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := commonName(); got != "renewed" {
+		t.Errorf("certificate = %v, should be reloaded once renewed", got)
+	}
+
+	// A broken certificate keeps the previous one
+	if err := os.WriteFile(filepath.Join(dir, "server.crt"), []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	evenLater := later.Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(dir, "server.crt"), evenLater, evenLater); err != nil {
+		t.Fatal(err)
+	}
+	if got := commonName(); got != "renewed" {
+		t.Errorf("certificate = %v, should keep the previous one when invalid", got)
+	}
+}
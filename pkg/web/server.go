@@ -0,0 +1,168 @@
+package web
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
+	"github.com/sirupsen/logrus"
+)
+
+// configLoader reads the web configuration file again when it changes, so that it can be updated without restarting
+// the exporter. If a new version is invalid, the previous one is kept.
+type configLoader struct {
+	path string
+
+	mu      sync.Mutex
+	config  *Config
+	tls     *tls.Config
+	modTime time.Time
+	// Last valid certificate, kept if the files become invalid while the certificate is renewed
+	cert *tls.Certificate
+
+	cache *authCache
+}
+
+func newConfigLoader(path string) (*configLoader, error) {
+	l := &configLoader{path: path, cache: newAuthCache()}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.load(stat.ModTime()); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *configLoader) load(modTime time.Time) error {
+	config, err := LoadConfig(l.path)
+	if err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if config.tlsEnabled() {
+		if tlsConfig, err = web.ConfigToTLSConfig(&config.TLSConfig); err != nil {
+			return err
+		}
+	}
+	l.config = config
+	l.tls = tlsConfig
+	l.modTime = modTime
+	l.cache.reset()
+	return nil
+}
+
+// current returns the configuration, after reading it again if the file changed.
+func (l *configLoader) current() (*Config, *tls.Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stat, err := os.Stat(l.path)
+	if err != nil {
+		logrus.Errorln("Can't read the web configuration, keeping the previous one:", err)
+	} else if !stat.ModTime().Equal(l.modTime) {
+		if err := l.load(stat.ModTime()); err != nil {
+			logrus.Errorln("Error while reloading the web configuration, keeping the previous one:", err)
+			// Don't try again until the file changes
+			l.modTime = stat.ModTime()
+		} else {
+			logrus.Infoln("Reloaded web configuration", l.path)
+		}
+	}
+	return l.config, l.tls
+}
+
+// getConfigForClient returns the TLS configuration of a new connection. The exporter-toolkit reads the certificate
+// again for each connection.
+func (l *configLoader) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	_, tlsConfig := l.current()
+	if tlsConfig == nil {
+		return nil, errors.New("TLS was disabled in the web configuration, restart the exporter to serve HTTP")
+	}
+	cert, err := tlsConfig.GetCertificate(hello)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		if l.cert == nil {
+			return nil, err
+		}
+		logrus.Errorln("Error while reloading the TLS certificate, keeping the previous one:", err)
+		cert = l.cert
+	}
+	l.cert = cert
+	res := tlsConfig.Clone()
+	res.GetCertificate = nil
+	res.Certificates = []tls.Certificate{*cert}
+	return res, nil
+}
+
+// handler checks the authentication of the requests, except for the public paths, and adds the configured headers.
+func (l *configLoader) handler(next http.Handler, publicPaths []string) http.Handler {
+	public := map[string]bool{}
+	for _, p := range publicPaths {
+		public[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config, _ := l.current()
+		for header, value := range config.HTTPConfig.Header {
+			w.Header().Set(header, value)
+		}
+		if !public[r.URL.Path] && !l.cache.authenticate(config, r) {
+			if len(config.Users) > 0 {
+				w.Header().Add("WWW-Authenticate", `Basic realm="cypress-dashboard-exporter"`)
+			}
+			if len(config.BearerAuthTokens) > 0 {
+				w.Header().Add("WWW-Authenticate", `Bearer realm="cypress-dashboard-exporter"`)
+			}
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ListenAndServe serves HTTP, or HTTPS if the web configuration file enables TLS, with its authentication. The
+// public paths, like the health checks, don't require authentication.
+// Without web configuration file, it's the same as server.ListenAndServe.
+// Enabling or disabling TLS requires a restart, other changes of the file are applied to the next connections.
+func ListenAndServe(server *http.Server, configFile string, publicPaths ...string) error {
+	if configFile == "" {
+		return server.ListenAndServe()
+	}
+	loader, err := newConfigLoader(configFile)
+	if err != nil {
+		return err
+	}
+	server.Handler = loader.handler(server.Handler, publicPaths)
+
+	config, _ := loader.current()
+	if !config.tlsEnabled() {
+		return server.ListenAndServe()
+	}
+	if !config.HTTPConfig.HTTP2 {
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	server.TLSConfig = &tls.Config{
+		// Never called as GetConfigForClient returns the certificate, but required to start without certificate files
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			config, err := loader.getConfigForClient(hello)
+			if err != nil {
+				return nil, err
+			}
+			return &config.Certificates[0], nil
+		},
+	}
+	server.TLSConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config, err := loader.getConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		config.NextProtos = server.TLSConfig.NextProtos
+		return config, nil
+	}
+	return server.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConfigLoader_handler(t *testing.T) {
+	dir := t.TempDir()
+	loader, err := newConfigLoader(writeConfig(t, dir, "basic_auth_users:\n  prometheus: "+secretHash+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := loader.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), []string{"/healthz", "/ready"})
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/metrics", http.StatusUnauthorized},
+		{"/probe", http.StatusUnauthorized},
+		{"/healthz", http.StatusOK},
+		{"/ready", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("GET %v = %v, want %v", tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}